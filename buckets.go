@@ -19,7 +19,6 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/urfave/cli"
 )
@@ -105,9 +104,7 @@ func createBucket(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 		return fmt.Errorf("the bucket already exists")
 	}
 
-	if _, err := cmd.s3Client.CreateBucket(&s3.CreateBucketInput{
-		Bucket: aws.String(name),
-	}); err != nil {
+	if err := cmd.store.CreateBucket(name); err != nil {
 		return err
 	}
 
@@ -143,23 +140,17 @@ func deleteBucket(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	// step: delete all the keys in the bucket first
 	// @TODO find of there is a force deletion api call
 	if count > 0 {
-		files, err := cmd.listBucketKeys(name, "")
-		if err != nil {
-			return err
-		}
-		for _, x := range files {
-			if _, err := cmd.s3Client.DeleteObject(&s3.DeleteObjectInput{
-				Bucket: aws.String(name),
-				Key:    x.Key,
-			}); err != nil {
+		if err := cmd.walkBucketKeys(name, "", "", func(x *s3.Object) error {
+			if err := cmd.removeFile(name, *x.Key); err != nil {
 				return fmt.Errorf("failed to remove the file: %s from bucket, error: %s", *x.Key, err)
 			}
+			return nil
+		}); err != nil {
+			return err
 		}
 	}
 	// step: delete the bucket
-	if _, err := cmd.s3Client.DeleteBucket(&s3.DeleteBucketInput{
-		Bucket: aws.String(name),
-	}); err != nil {
+	if err := cmd.store.DeleteBucket(name); err != nil {
 		return err
 	}
 
@@ -0,0 +1,137 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+//
+// testBuckets returns the names of the buckets in the store
+//
+func testBuckets(t *testing.T, cmd *cliCommand) []string {
+	list, err := cmd.listS3Buckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, x := range list {
+		names = append(names, *x.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestBucketsCommand(t *testing.T) {
+	cases := []struct {
+		// the subcommand to run
+		command string
+		method  testMethod
+		args    []string
+		buckets []string
+		error   bool
+	}{
+		{
+			command: "create",
+			method:  createBucket,
+			args:    []string{"--bucket", "other"},
+			buckets: []string{"empty", "other", testBucket},
+		},
+		{
+			command: "create",
+			method:  createBucket,
+			args:    []string{"--bucket", testBucket},
+			error:   true,
+		},
+		{
+			command: "delete",
+			method:  deleteBucket,
+			args:    []string{"--bucket", "empty"},
+			buckets: []string{testBucket},
+		},
+		{
+			command: "delete",
+			method:  deleteBucket,
+			args:    []string{"--bucket", testBucket},
+			error:   true,
+		},
+		{
+			command: "delete",
+			method:  deleteBucket,
+			args:    []string{"--bucket", testBucket, "--force"},
+			buckets: []string{"empty"},
+		},
+		{
+			command: "delete",
+			method:  deleteBucket,
+			args:    []string{"--bucket", "missing"},
+			error:   true,
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		if err := cmd.store.CreateBucket("empty"); err != nil {
+			t.Fatal(err)
+		}
+		putTestFile(t, cmd, "a.txt", "a", false)
+
+		var command cli.Command
+		for _, x := range newBucketsCommand(cmd).Subcommands {
+			if x.Name == c.command {
+				command = x
+			}
+		}
+		_, err := runTestCommand(t, cmd, command, c.method, c.args...)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if buckets := testBuckets(t, cmd); !reflect.DeepEqual(buckets, c.buckets) {
+			t.Errorf("case %d: expected the buckets: %v, got: %v", i, c.buckets, buckets)
+		}
+	}
+}
+
+func TestListBuckets(t *testing.T) {
+	cmd := newTestCommand(t)
+	if err := cmd.store.CreateBucket("other"); err != nil {
+		t.Fatal(err)
+	}
+	output, err := runTestCommand(t, cmd, newBucketsCommand(cmd), listBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		names = append(names, strings.Fields(line)[0])
+	}
+	sort.Strings(names)
+	if expected := []string{"other", testBucket}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected the buckets: %v, got: %v", expected, names)
+	}
+}
@@ -35,6 +35,14 @@ func newCatCommand(cmd *cliCommand) cli.Command {
 				Usage:  "the name of the s3 bucket containing the encrypted files",
 				EnvVar: "AWS_S3_BUCKET",
 			},
+			cli.StringFlag{
+				Name:  "version-id",
+				Usage: "retrieve a specific version of the file rather than the latest",
+			},
+			cli.StringFlag{
+				Name:  "field",
+				Usage: "display the value of a dotted field, i.e. db.password, from a json, yaml or dotenv file",
+			},
 		},
 		Action: func(cx *cli.Context) error {
 			return handleCommand(cx, []string{"l:bucket:s"}, cmd, catFiles)
@@ -47,14 +55,45 @@ func newCatCommand(cmd *cliCommand) cli.Command {
 //
 func catFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	bucket := cx.String("bucket")
+	versionID := cx.String("version-id")
+	field := cx.String("field")
+
+	if versionID != "" && len(cx.Args()) > 1 {
+		return fmt.Errorf("you can only specify a single file when retrieving a specific version")
+	}
 
 	for _, filename := range cx.Args() {
-		content, err := cmd.getFile(bucket, filename)
-		if err != nil {
+		if field != "" {
+			if err := catField(cmd, bucket, filename, versionID, field); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := cmd.streamFile(bucket, filename, versionID, os.Stdout); err != nil {
 			return err
 		}
-		fmt.Fprintf(os.Stdout, "%s", content)
 	}
 
 	return nil
 }
+
+//
+// catField displays the value of a field from a structured file
+//
+func catField(cmd *cliCommand, bucket, key, versionID, field string) error {
+	content, err := cmd.getFileVersion(bucket, key, versionID)
+	if err != nil {
+		return err
+	}
+	document, err := decodeDocument(key, content)
+	if err != nil {
+		return err
+	}
+	value, err := lookupVariable(document, key, field)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%s\n", value)
+
+	return nil
+}
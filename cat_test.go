@@ -0,0 +1,105 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestCatFiles(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected string
+		error    bool
+	}{
+		{
+			args:     []string{"a.txt"},
+			expected: "second\n",
+		},
+		{
+			args:     []string{"a.txt", "app.env"},
+			expected: "second\nPASSWORD=secret\nUSER=app\n",
+		},
+		{
+			args:     []string{"--version-id", "first", "a.txt"},
+			expected: "first\n",
+		},
+		{
+			args:     []string{"--field", "db.password", "config.json"},
+			expected: "secret\n",
+		},
+		{
+			args:     []string{"--field", "account", "config.json"},
+			expected: "123456789012\n",
+		},
+		{
+			args:     []string{"--field", "db.port", "config.yaml"},
+			expected: "5432\n",
+		},
+		{
+			args:     []string{"--field", "USER", "app.env"},
+			expected: "app\n",
+		},
+		{
+			args:  []string{"--field", "db.missing", "config.json"},
+			error: true,
+		},
+		{
+			args:  []string{"--version-id", "first", "a.txt", "app.env"},
+			error: true,
+		},
+		{
+			args:  []string{"missing.txt"},
+			error: true,
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		putTestFile(t, cmd, "a.txt", "first\n", false)
+		first, err := cmd.getFileMetadata("a.txt", testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		putTestFile(t, cmd, "a.txt", "second\n", false)
+		putTestFile(t, cmd, "app.env", "PASSWORD=secret\nUSER=app\n", true)
+		putTestFile(t, cmd, "config.json", `{"account": 123456789012, "db": {"password": "secret"}}`, false)
+		putTestFile(t, cmd, "config.yaml", "db:\n  port: 5432\n", true)
+		for j, x := range c.args {
+			if x == "first" {
+				c.args[j] = *first.VersionId
+			}
+		}
+
+		args := append([]string{"--bucket", testBucket}, c.args...)
+		output, err := captureStdout(t, func() error {
+			_, err := runTestCommand(t, cmd, newCatCommand(cmd), catFiles, args...)
+			return err
+		})
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if output != c.expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, output)
+		}
+	}
+}
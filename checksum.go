@@ -0,0 +1,124 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// checksumHeader is the metadata holding a keyed hmac-sha256 of the plaintext, so the content can be
+	// compared with a local file without retrieving it
+	checksumHeader = "s3secrets-checksum"
+	// checksumKeyHeader is the metadata holding the kms wrapped key of the checksum of a server side encrypted
+	// object, envelope encrypted objects derive the key from their data key
+	checksumKeyHeader = "s3secrets-checksum-key"
+)
+
+// checksumLabel separates the key of the checksum from the key it's derived from
+var checksumLabel = []byte("s3secrets-checksum")
+
+//
+// checksumMetadata generates a checksum key from the kms key and returns the metadata holding the checksum
+// of the content and the wrapped key. The content is read and rewound, so a body which cannot seek, i.e.
+// stdin, has no checksum and nil is returned
+//
+func (r *cliCommand) checksumMetadata(body io.Reader, kmsID string) (map[string]*string, error) {
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return nil, nil
+	}
+	offset, err := seeker.Seek(0, os.SEEK_CUR)
+	if err != nil {
+		return nil, nil
+	}
+
+	plaintext, wrapped, _, err := r.keyService.GenerateDataKey(kmsID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate a checksum key, error: %s", err)
+	}
+	defer zeroBytes(plaintext)
+
+	checksum, err := computeChecksum(plaintext, body)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := seeker.Seek(offset, os.SEEK_SET); err != nil {
+		return nil, err
+	}
+
+	return map[string]*string{
+		checksumHeader:    aws.String(checksum),
+		checksumKeyHeader: aws.String(base64.StdEncoding.EncodeToString(wrapped)),
+	}, nil
+}
+
+//
+// matchChecksum compares the content with the checksum of the object, verified is false when the object
+// has no checksum we can verify, in which case the content of the object must be compared instead
+//
+func (r *cliCommand) matchChecksum(head *s3.HeadObjectOutput, content io.Reader) (matched, verified bool) {
+	expected, found := getMetadata(head.Metadata, checksumHeader)
+	if !found {
+		return false, false
+	}
+	// step: the checksum key of a envelope encrypted object is derived from the data key
+	header := checksumKeyHeader
+	if isEnvelope(head.Metadata) {
+		header = envelopeKeyHeader
+	}
+	wrapped, err := decodeMetadata(head.Metadata, header)
+	if err != nil {
+		return false, false
+	}
+	kmsID, _ := objectEncryption(head)
+	plaintext, _, err := r.keyService.Decrypt(kmsID, wrapped)
+	if err != nil {
+		return false, false
+	}
+	defer zeroBytes(plaintext)
+
+	checksum, err := computeChecksum(plaintext, content)
+	if err != nil {
+		return false, false
+	}
+
+	return hmac.Equal([]byte(checksum), []byte(expected)), true
+}
+
+//
+// computeChecksum returns the base64 encoded hmac-sha256 of the content, keyed by a key derived from the
+// data key
+//
+func computeChecksum(dataKey []byte, content io.Reader) (string, error) {
+	derived := hmac.New(sha256.New, dataKey)
+	derived.Write(checksumLabel)
+
+	mac := hmac.New(sha256.New, derived.Sum(nil))
+	if _, err := io.Copy(mac, content); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
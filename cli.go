@@ -18,24 +18,23 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/kms"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/urfave/cli"
 )
 
 type cliCommand struct {
-	// the kms client for aws
-	kmsClient *kms.KMS
-	// the s3 client
-	s3Client *s3.S3
-	// the s3 uploader
-	uploader *s3manager.Uploader
+	// the store holding the encrypted files
+	store SecretStore
+	// the key service used to protect the data keys
+	keyService KeyService
+	// the name of the active context
+	context string
 }
 
 func newCliApplication() *cli.App {
@@ -50,7 +49,7 @@ func newCliApplication() *cli.App {
 	app.Flags = []cli.Flag{
 		cli.StringFlag{
 			Name:   "p, profile",
-			Usage:  "the aws profile to use for static credentials",
+			Usage:  "the aws profile to use from the shared credentials and config files",
 			EnvVar: "AWS_DEFAULT_PROFILE",
 		},
 		cli.StringFlag{
@@ -59,6 +58,12 @@ func newCliApplication() *cli.App {
 			EnvVar: "AWS_SHARED_CREDENTIALS_FILE",
 			Value:  os.Getenv("HOME") + "/.aws/credentials",
 		},
+		cli.StringFlag{
+			Name:   "aws-config",
+			Usage:  "the path to the aws config file containing the profile settings, i.e. role_arn or region",
+			EnvVar: "AWS_CONFIG_FILE",
+			Value:  os.Getenv("HOME") + "/.aws/config",
+		},
 		cli.StringFlag{
 			Name:   "access-key",
 			Usage:  "the aws access key to use to access the resources",
@@ -74,6 +79,40 @@ func newCliApplication() *cli.App {
 			Usage:  "the aws session token to use when accessing the resources",
 			EnvVar: "AWS_SESSION_TOKEN",
 		},
+		cli.StringFlag{
+			Name:   "role-arn",
+			Usage:  "the arn of a role to assume, using the credentials given by the other options",
+			EnvVar: "AWS_ROLE_ARN",
+		},
+		cli.StringFlag{
+			Name:   "role-session-name",
+			Usage:  "the name of the session when assuming the role",
+			EnvVar: "AWS_ROLE_SESSION_NAME",
+			Value:  progName,
+		},
+		cli.DurationFlag{
+			Name:  "role-duration",
+			Usage: "the duration of the session when assuming the role",
+			Value: time.Duration(1 * time.Hour),
+		},
+		cli.StringFlag{
+			Name:  "external-id",
+			Usage: "the external id required by the trust policy of the role",
+		},
+		cli.StringFlag{
+			Name:  "mfa-serial",
+			Usage: "the serial or arn of the mfa device required to assume the role, you are prompted for the code",
+		},
+		cli.StringFlag{
+			Name:   "web-identity-token-file",
+			Usage:  "the path to a web identity token used to assume the role, i.e. a kubernetes service account token",
+			EnvVar: "AWS_WEB_IDENTITY_TOKEN_FILE",
+		},
+		cli.StringFlag{
+			Name:  "credentials-cache",
+			Usage: "the directory to cache the credentials of the assumed role in, an empty value disables the cache",
+			Value: os.Getenv("HOME") + "/.s3secrets/cache",
+		},
 		cli.StringFlag{
 			Name:  "endpoint-url",
 			Usage: "the aws s3 endpoint to use",
@@ -82,9 +121,24 @@ func newCliApplication() *cli.App {
 			Name:  "s3-path-style",
 			Usage: "use a path instead of DNS for bucket name",
 		},
+		cli.StringFlag{
+			Name:  "local-dir",
+			Usage: "use a local directory as the bucket store and keyring rather than aws, i.e. for offline use",
+		},
 		cli.StringFlag{
 			Name:  "environment-file",
-			Usage: "a file containing a list of environment variables",
+			Usage: "a dotenv file of environment variables to load, i.e. AWS_S3_BUCKET, variables already in the environment take precedence",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "the path to the config file containing the named contexts",
+			EnvVar: "S3SECRETS_CONFIG",
+			Value:  os.Getenv("HOME") + "/.s3secrets.yaml",
+		},
+		cli.StringFlag{
+			Name:   "context",
+			Usage:  "the named context to use rather than the current context in the config file",
+			EnvVar: "S3SECRETS_CONTEXT",
 		},
 		cli.StringFlag{
 			Name:   "r, region",
@@ -108,9 +162,18 @@ func newCliApplication() *cli.App {
 		newListCommand(cmd),
 		newDeleteCommand(cmd),
 		newCatCommand(cmd),
+		newExecCommand(cmd),
 		newGetCommand(cmd),
 		newPutCommand(cmd),
+		newDiffCommand(cmd),
+		newPushCommand(cmd),
+		newGenerateCommand(cmd),
 		newEditCommand(cmd),
+		newSetCommand(cmd),
+		newRollbackCommand(cmd),
+		newRekeyCommand(cmd),
+		newTemplateCommand(cmd),
+		newContextCommand(cmd),
 	}
 
 	return app
@@ -172,6 +235,10 @@ func handleCommand(cx *cli.Context, options []string, cmd *cliCommand, method fu
 	if err != nil {
 		printError("error: %s", err)
 	}
+	// step: show the context being used by anything which changes the bucket
+	if cmd.context != "" && isMutating(cx) {
+		writer.log("using the context: %s\n", cmd.context)
+	}
 
 	// step: call the command and handle any errors
 	if err := method(writer, cx, cmd); err != nil {
@@ -186,16 +253,65 @@ func handleCommand(cx *cli.Context, options []string, cmd *cliCommand, method fu
 //
 func (r *cliCommand) getCredentials() func(cx *cli.Context) error {
 	return func(cx *cli.Context) error {
+		// step: load the active context, which provides the defaults for the options
+		settings, err := loadContextConfig(cx.GlobalString("config"))
+		if err != nil {
+			return err
+		}
+		name, context, err := settings.active(cx.GlobalString("context"))
+		// step: the context command needs no credentials and must be usable to fix a missing context
+		if cx.Args().First() == "context" {
+			r.context = name
+
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if context == nil {
+			context = &secretsContext{}
+		}
+		applyContext(cx.App.Commands, context)
+		r.context = name
+		profile := contextOption(cx, "profile", "AWS_DEFAULT_PROFILE", context.Profile)
+		endpoint := contextOption(cx, "endpoint-url", "", context.Endpoint)
+
+		// step: are we using a local directory rather than aws?
+		if directory := cx.GlobalString("local-dir"); directory != "" {
+			keyService, err := newLocalKeyService(filepath.Join(directory, localKeysDir))
+			if err != nil {
+				return err
+			}
+			store, err := newLocalStore(directory, keyService)
+			if err != nil {
+				return err
+			}
+			r.store = store
+			r.keyService = keyService
+
+			return nil
+		}
+
+		// step: load the shared profiles, the region of the profile is used when not otherwise given
+		profiles, err := loadSharedProfiles(cx.GlobalString("credentials"), cx.GlobalString("aws-config"))
+		if err != nil {
+			return err
+		}
+		region := context.Region
+		if region == "" {
+			region = profiles.region(sharedProfileName(profile))
+		}
+		region = contextOption(cx, "region", "AWS_DEFAULT_REGION", region)
+
 		// step: ensure we have a region
-		if cx.GlobalString("region") == "" {
+		if region == "" {
 			fmt.Fprintf(os.Stderr, "[error] you have not specified the aws region the resources reside\n")
 			os.Exit(1)
 		}
 		config := &aws.Config{
-			Region: aws.String(cx.GlobalString("region")),
+			Region: aws.String(region),
 		}
-		if cx.GlobalString("endpoint-url") != "" {
-			endpoint := cx.GlobalString("endpoint-url")
+		if endpoint != "" {
 			config.Endpoint = &endpoint
 		}
 		if cx.GlobalBool("s3-path-style") {
@@ -203,6 +319,7 @@ func (r *cliCommand) getCredentials() func(cx *cli.Context) error {
 		}
 
 		// step: are we using static credentials
+		sourceID := os.Getenv("AWS_ACCESS_KEY_ID")
 		if cx.GlobalString("access-key") != "" || cx.GlobalString("secret-ket") != "" {
 			if cx.GlobalString("secret-key") == "" {
 				return fmt.Errorf("you have specified a access key with a secret key")
@@ -213,17 +330,32 @@ func (r *cliCommand) getCredentials() func(cx *cli.Context) error {
 			config.Credentials = credentials.NewStaticCredentials(cx.GlobalString("access-key"),
 				cx.GlobalString("secret-key"),
 				cx.GlobalString("session-token"))
-		} else if cx.GlobalString("profile") != "" {
-			config.Credentials = credentials.NewSharedCredentials(
-				cx.GlobalString("credentials"),
-				cx.GlobalString("profile"))
+			sourceID = cx.GlobalString("access-key")
+		} else if profile != "" {
+			creds, err := profiles.resolve(profile, config, cx.GlobalString("credentials-cache"), make(map[string]bool, 0))
+			if err != nil {
+				return err
+			}
+			config.Credentials = creds
+			sourceID = "profile:" + profile
+		}
 
+		// step: are we assuming a role with the credentials?
+		if cx.GlobalString("role-arn") != "" {
+			options := newRoleOptions(cx)
+			options.sourceID = sourceID
+			creds, err := options.assumeRole(config)
+			if err != nil {
+				return err
+			}
+			config.Credentials = creds
+		} else if cx.GlobalString("web-identity-token-file") != "" {
+			return fmt.Errorf("you must specify the role to assume with the web identity token")
 		}
 
-		// step: create the clients
-		r.s3Client = s3.New(session.New(config))
-		r.kmsClient = kms.New(session.New(config))
-		r.uploader = s3manager.NewUploader(session.New(config))
+		// step: create the clients, the bucket regions are detected unless we are using a custom endpoint
+		r.store = newAWSStore(session.New(config), endpoint == "")
+		r.keyService = newAWSKeyService(session.New(config))
 
 		return nil
 	}
@@ -16,9 +16,12 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -26,6 +29,9 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
+// errStopWalk is returned by a walk method to stop the listing early
+var errStopWalk = errors.New("stop walking the keys")
+
 //
 // hasBucket checks if the bucket exists
 //
@@ -47,77 +53,209 @@ func (r cliCommand) hasBucket(bucket string) (bool, error) {
 // listS3Buckets gets a list of buckets
 //
 func (r cliCommand) listS3Buckets() ([]*s3.Bucket, error) {
-	list, err := r.s3Client.ListBuckets(&s3.ListBucketsInput{})
-	if err != nil {
-		return nil, err
-	}
-
-	return list.Buckets, nil
+	return r.store.ListBuckets()
 }
 
 //
 // getFileMetadata returns the head data for the specific key
 //
 func (r cliCommand) getFileMetadata(key, bucket string) (*s3.HeadObjectOutput, error) {
-	return r.s3Client.HeadObject(&s3.HeadObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	return r.getFileVersionMetadata(key, bucket, "")
+}
+
+//
+// getFileVersionMetadata returns the head data for a specific version of the key
+//
+func (r cliCommand) getFileVersionMetadata(key, bucket, versionID string) (*s3.HeadObjectOutput, error) {
+	return r.store.Head(bucket, key, versionID)
 }
 
 //
 // getFile retrieves the content from a file in the bucket
 //
 func (r *cliCommand) getFile(bucket, key string) ([]byte, error) {
+	return r.getFileVersion(bucket, key, "")
+}
+
+//
+// getFileVersion retrieves the content from a specific version of a file in the bucket, an empty
+// version is the current version
+//
+func (r *cliCommand) getFileVersion(bucket, key, versionID string) ([]byte, error) {
+	content := new(bytes.Buffer)
+	if err := r.streamFile(bucket, key, versionID, content); err != nil {
+		return nil, err
+	}
+
+	return content.Bytes(), nil
+}
+
+//
+// streamFile copies the content of a file in the bucket to the writer as it's retrieved, client side
+// encrypted files must be decrypted in memory as the cipher authenticates the content as a whole
+//
+func (r *cliCommand) streamFile(bucket, key, versionID string, w io.Writer) error {
 	// step: retrieve the object from the bucket
-	resp, err := r.s3Client.GetObject(&s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+	resp, err := r.store.Get(bucket, key, versionID)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	// step: read the content
-	content, err := ioutil.ReadAll(resp.Body)
+	defer resp.Body.Close()
+
+	return r.copyObject(bucket, key, resp, w)
+}
+
+//
+// getFileWithETag retrieves the content of the current version of a file in the bucket along with the
+// etag of the version retrieved
+//
+func (r *cliCommand) getFileWithETag(bucket, key string) ([]byte, string, error) {
+	content, resp, err := r.getFileRevision(bucket, key)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return content, nil
+	return content, aws.StringValue(resp.ETag), nil
 }
 
 //
-// removeFile removes a file from a bucket
+// getFileRevision retrieves the content of the current version of a file in the bucket along with the
+// response, so the etag and encryption describe the version retrieved
 //
-func (r *cliCommand) removeFile(bucket, key string) error {
-	_, err := r.s3Client.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-	})
+func (r *cliCommand) getFileRevision(bucket, key string) ([]byte, *s3.GetObjectOutput, error) {
+	resp, err := r.store.Get(bucket, key, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	content := new(bytes.Buffer)
+	if err := r.copyObject(bucket, key, resp, content); err != nil {
+		return nil, nil, err
+	}
+
+	return content.Bytes(), resp, nil
+}
+
+//
+// checkFileETag ensures the current version of the file in the bucket is the one with the etag. Note, the
+// check is not atomic with any upload which follows.
+//
+func (r *cliCommand) checkFileETag(bucket, key, etag string) error {
+	current, err := r.getFileMetadata(key, bucket)
+	if err != nil {
+		return err
+	}
+	if aws.StringValue(current.ETag) != etag {
+		return fmt.Errorf("the file: %s has been changed in the bucket since it was retrieved", key)
+	}
+
+	return nil
+}
+
+//
+// copyObject copies the content of the object retrieved from the bucket and key to the writer, decrypting
+// it if required
+//
+func (r *cliCommand) copyObject(bucket, key string, resp *s3.GetObjectOutput, w io.Writer) error {
+	// step: are we client side encrypted?
+	if isEnvelope(resp.Metadata) {
+		content, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		plaintext, err := r.decryptEnvelope(bucket, key, content, resp.Metadata)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(plaintext)
+		return err
+	}
+	_, err := io.Copy(w, resp.Body)
 
 	return err
 }
 
+//
+// downloadFile retrieves a file from the bucket into the writer, server side encrypted files are retrieved
+// in concurrent ranged parts
+//
+func (r *cliCommand) downloadFile(bucket, key, versionID string, w io.WriterAt) error {
+	metadata, err := r.getFileVersionMetadata(key, bucket, versionID)
+	if err != nil {
+		return err
+	}
+	if isEnvelope(metadata.Metadata) {
+		content, err := r.getFileVersion(bucket, key, versionID)
+		if err != nil {
+			return err
+		}
+		_, err = w.WriteAt(content, 0)
+		return err
+	}
+	// step: ensure every part is from the object we have inspected, matching on the etag rather than the
+	// version, which would require s3:GetObjectVersion
+	_, err = r.store.Download(bucket, key, versionID, aws.StringValue(metadata.ETag), w)
+
+	return err
+}
+
+//
+// removeFile removes a file from a bucket
+//
+func (r *cliCommand) removeFile(bucket, key string) error {
+	return r.store.Delete(bucket, key)
+}
+
 //
 // putFile uploads a file to the bucket
 //
-func (r *cliCommand) putFile(bucket, key, path, kmsID string) error {
+func (r *cliCommand) putFile(bucket, key, path, kmsID string, envelope bool) error {
 	// step: open the file
 	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	// step: upload the file
-	_, err = r.uploader.Upload(&s3manager.UploadInput{
-		Bucket:               aws.String(bucket),
-		Key:                  aws.String(key),
-		Body:                 file,
-		ServerSideEncryption: aws.String("aws:kms"),
-		SSEKMSKeyId:          aws.String(kmsID),
-	})
+	return r.uploadFile(bucket, key, file, kmsID, envelope)
+}
 
-	return err
+//
+// uploadFile uploads the content to the bucket, encrypting either server side or client side
+// via a kms data key when envelope is set, along with a keyed checksum of the content
+//
+func (r *cliCommand) uploadFile(bucket, key string, body io.Reader, kmsID string, envelope bool) error {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+
+	switch envelope {
+	case true:
+		content, err := ioutil.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		encrypted, metadata, err := r.encryptEnvelope(bucket, key, content, kmsID)
+		if err != nil {
+			return err
+		}
+		input.Body = bytes.NewReader(encrypted)
+		input.Metadata = metadata
+	default:
+		metadata, err := r.checksumMetadata(body, kmsID)
+		if err != nil {
+			return err
+		}
+		input.Metadata = metadata
+		input.ServerSideEncryption = aws.String("aws:kms")
+		input.SSEKMSKeyId = aws.String(kmsID)
+	}
+
+	// step: upload the file
+	return r.store.Put(input)
 }
 
 //
@@ -126,51 +264,73 @@ func (r *cliCommand) putFile(bucket, key, path, kmsID string) error {
 func (r *cliCommand) listBucketKeys(bucket, prefix string) ([]*s3.Object, error) {
 	var list []*s3.Object
 
-	resp, err := r.s3Client.ListObjects(&s3.ListObjectsInput{
-		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
+	err := r.walkBucketKeys(bucket, prefix, "", func(x *s3.Object) error {
+		list = append(list, x)
+		return nil
 	})
-	if err != nil {
-		return nil, err
-	}
 
-	// step: filter out any keys which are directories
-	for _, x := range resp.Contents {
+	return list, err
+}
+
+//
+// walkBucketKeys pages through the keys in the bucket under the prefix, calling the method for each of
+// them. If a delimiter is given only the keys directly under the prefix are returned
+//
+func (r *cliCommand) walkBucketKeys(bucket, prefix, delimiter string, method func(*s3.Object) error) error {
+	return r.store.List(bucket, prefix, delimiter, func(x *s3.Object) error {
+		// step: filter out any keys which are directories
 		if strings.HasSuffix(*x.Key, "/") {
-			continue
+			return nil
 		}
-		list = append(list, x)
-	}
 
-	return list, nil
+		return method(x)
+	})
+}
+
+//
+// walkBucketVersions pages through the versions of the keys in the bucket under the prefix, newest first
+//
+func (r *cliCommand) walkBucketVersions(bucket, prefix string, method func(*s3.ObjectVersion) error) error {
+	return r.store.ListVersions(bucket, prefix, func(x *s3.ObjectVersion) error {
+		// step: filter out any keys which are directories
+		if strings.HasSuffix(*x.Key, "/") {
+			return nil
+		}
+
+		return method(x)
+	})
 }
 
 //
 // hasKey checks if the key exist in the bucket
 //
 func (r cliCommand) hasKey(key, bucket string) (bool, error) {
-	keys, err := r.listBucketKeys(bucket, filepath.Dir(key))
-	if err != nil {
-		return false, err
-	}
+	var found bool
 
-	for _, k := range keys {
-		if key == *k.Key {
-			return true, nil
+	err := r.walkBucketKeys(bucket, key, "", func(x *s3.Object) error {
+		if key == *x.Key {
+			found = true
+			return errStopWalk
 		}
+		return nil
+	})
+	if err == errStopWalk {
+		err = nil
 	}
 
-	return false, nil
+	return found, err
 }
 
 //
 // sizeOfBucket gets the number of objects in the bucket
 //
 func (r cliCommand) sizeOfBucket(name string) (int, error) {
-	files, err := r.listBucketKeys(name, "")
-	if err != nil {
-		return 0, err
-	}
+	var count int
+
+	err := r.walkBucketKeys(name, "", "", func(x *s3.Object) error {
+		count++
+		return nil
+	})
 
-	return len(files), nil
+	return count, err
 }
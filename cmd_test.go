@@ -0,0 +1,249 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+const (
+	// testBucket is the bucket the commands are tested against
+	testBucket = "test"
+	// testKMS is the kms key the test files are encrypted with
+	testKMS = "alias/test"
+)
+
+// testMethod is the signature of the command methods under test
+type testMethod func(*formatter, *cli.Context, *cliCommand) error
+
+//
+// newTestCommand creates a command backed by the in-memory store and key service, with the test bucket
+// already created
+//
+func newTestCommand(t *testing.T) *cliCommand {
+	cmd := &cliCommand{store: newMemoryStore(), keyService: newMemoryKeyService()}
+	if err := cmd.store.CreateBucket(testBucket); err != nil {
+		t.Fatalf("unable to create the test bucket, error: %s", err)
+	}
+
+	return cmd
+}
+
+//
+// newTestFlagSet parses the arguments against the flags, as the cli would
+//
+func newTestFlagSet(t *testing.T, name string, flags []cli.Flag, args ...string) *flag.FlagSet {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	set.SetOutput(ioutil.Discard)
+	for _, x := range flags {
+		x.Apply(set)
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatalf("unable to parse the arguments: %v, error: %s", args, err)
+	}
+
+	return set
+}
+
+//
+// newTestContext creates the context of the command from the arguments
+//
+func newTestContext(t *testing.T, command cli.Command, args ...string) *cli.Context {
+	return cli.NewContext(cli.NewApp(), newTestFlagSet(t, command.Name, command.Flags, args...), nil)
+}
+
+//
+// runTestMethod runs the method of a command with the context, returning the text output
+//
+func runTestMethod(t *testing.T, cmd *cliCommand, cx *cli.Context, method testMethod) (string, error) {
+	output := new(bytes.Buffer)
+	o, err := newFormatter("text", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = method(o, cx, cmd)
+
+	return output.String(), err
+}
+
+//
+// runTestCommand runs the method of the command with the arguments, returning the text output
+//
+func runTestCommand(t *testing.T, cmd *cliCommand, command cli.Command, method testMethod, args ...string) (string, error) {
+	return runTestMethod(t, cmd, newTestContext(t, command, args...), method)
+}
+
+//
+// putTestFile uploads the content into the test bucket
+//
+func putTestFile(t *testing.T, cmd *cliCommand, key, content string, envelope bool) {
+	if err := cmd.uploadFile(testBucket, key, strings.NewReader(content), testKMS, envelope); err != nil {
+		t.Fatalf("unable to upload the file: %s, error: %s", key, err)
+	}
+}
+
+//
+// getTestFile retrieves the content of a file in the test bucket
+//
+func getTestFile(t *testing.T, cmd *cliCommand, key string) string {
+	content, err := cmd.getFile(testBucket, key)
+	if err != nil {
+		t.Fatalf("unable to retrieve the file: %s, error: %s", key, err)
+	}
+
+	return string(content)
+}
+
+//
+// testKeys returns the keys in the test bucket
+//
+func testKeys(t *testing.T, cmd *cliCommand) []string {
+	list, err := cmd.listBucketKeys(testBucket, "")
+	if err != nil {
+		t.Fatalf("unable to list the bucket, error: %s", err)
+	}
+	var keys []string
+	for _, x := range list {
+		keys = append(keys, *x.Key)
+	}
+
+	return keys
+}
+
+//
+// newTestDir creates a temporary directory holding the files, removed by the returned method
+//
+func newTestDir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "s3secrets-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+//
+// captureStdout returns what the method writes to stdout
+//
+func captureStdout(t *testing.T, method func() error) (string, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+
+	outputCh := make(chan []byte, 1)
+	go func() {
+		content, _ := ioutil.ReadAll(reader)
+		outputCh <- content
+	}()
+	err = method()
+	os.Stdout = stdout
+	writer.Close()
+
+	return string(<-outputCh), err
+}
+
+func TestFileRoundTrip(t *testing.T) {
+	cases := []struct {
+		key      string
+		content  string
+		envelope bool
+	}{
+		{key: "plain.txt", content: "hello world\n"},
+		{key: "envelope.txt", content: "hello world\n", envelope: true},
+		{key: "dir/empty", content: ""},
+		{key: "dir/envelope-empty", content: "", envelope: true},
+	}
+	cmd := newTestCommand(t)
+	for _, c := range cases {
+		putTestFile(t, cmd, c.key, c.content, c.envelope)
+		if content := getTestFile(t, cmd, c.key); content != c.content {
+			t.Errorf("case %s: expected content: %q, got: %q", c.key, c.content, content)
+		}
+		metadata, err := cmd.getFileMetadata(c.key, testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kmsID, envelope := objectEncryption(metadata); kmsID == "" || envelope != c.envelope {
+			t.Errorf("case %s: expected envelope: %t, got: %t, kms: %s", c.key, c.envelope, envelope, kmsID)
+		}
+	}
+}
+
+func TestCheckFileETag(t *testing.T) {
+	cmd := newTestCommand(t)
+	putTestFile(t, cmd, "a.txt", "first", false)
+	content, resp, err := cmd.getFileRevision(testBucket, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "first" {
+		t.Errorf("expected the content: first, got: %q", content)
+	}
+	if kmsID, envelope := retrievedEncryption(resp); kmsID != testKMS || envelope {
+		t.Errorf("expected the kms: %s, got: %s, envelope: %t", testKMS, kmsID, envelope)
+	}
+	etag := aws.StringValue(resp.ETag)
+	if err := cmd.checkFileETag(testBucket, "a.txt", etag); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	putTestFile(t, cmd, "a.txt", "second", false)
+	if err := cmd.checkFileETag(testBucket, "a.txt", etag); err == nil {
+		t.Errorf("expected an error as the file has been changed")
+	}
+}
+
+func TestEnvelopeBinding(t *testing.T) {
+	cmd := newTestCommand(t)
+	putTestFile(t, cmd, "a.txt", "hello world\n", true)
+
+	// step: the ciphertext and it's metadata copied to another key must not decrypt
+	err := cmd.store.Copy(&s3.CopyObjectInput{
+		Bucket:     aws.String(testBucket),
+		Key:        aws.String("b.txt"),
+		CopySource: aws.String(copySource(testBucket, "a.txt", "")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmd.getFile(testBucket, "b.txt"); err == nil {
+		t.Errorf("expected the envelope moved to another key to be rejected")
+	}
+	if content := getTestFile(t, cmd, "a.txt"); content != "hello world\n" {
+		t.Errorf("expected content: %q, got: %q", "hello world\n", content)
+	}
+}
@@ -0,0 +1,415 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// contextConfig is the config file holding the named contexts
+type contextConfig struct {
+	// the name of the context used by default
+	CurrentContext string `yaml:"current-context,omitempty"`
+	// the contexts, keyed by name
+	Contexts map[string]*secretsContext `yaml:"contexts,omitempty"`
+}
+
+// secretsContext is a named set of defaults for the options
+type secretsContext struct {
+	// the bucket containing the encrypted files
+	Bucket string `yaml:"bucket,omitempty"`
+	// the kms key used to encrypt the files
+	KMS string `yaml:"kms,omitempty"`
+	// the aws region
+	Region string `yaml:"region,omitempty"`
+	// the aws profile
+	Profile string `yaml:"profile,omitempty"`
+	// the s3 endpoint
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// the prefix inside the bucket files are uploaded under
+	Prefix string `yaml:"prefix,omitempty"`
+}
+
+// contextVariables are the environment variables of the flags which take their defaults from the context
+var contextVariables = map[string]func(*secretsContext) string{
+	"AWS_S3_BUCKET":    func(x *secretsContext) string { return x.Bucket },
+	"AWS_KMS_ID":       func(x *secretsContext) string { return x.KMS },
+	"S3SECRETS_PREFIX": func(x *secretsContext) string { return x.Prefix },
+}
+
+// mutatingCommands are the commands which change the bucket, the context is shown when they are used
+var mutatingCommands = map[string]bool{
+	"buckets create": true,
+	"buckets delete": true,
+	"delete":         true,
+	"edit":           true,
+	"generate":       true,
+	"push":           true,
+	"put":            true,
+	"rekey":          true,
+	"rollback":       true,
+	"set":            true,
+}
+
+//
+// newContextCommand creates a new context command
+//
+func newContextCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "context",
+		Usage: "manage the named contexts providing the default bucket, kms key, region and profile",
+		Subcommands: []cli.Command{
+			{
+				Name:  "ls, list",
+				Usage: "list the contexts in the config file, the current context is marked with a *",
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{}, cmd, listContexts)
+				},
+			},
+			{
+				Name:      "use",
+				Usage:     "change the current context",
+				ArgsUsage: "NAME",
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{}, cmd, useContext)
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "display the settings of a context, defaulting to the active context",
+				ArgsUsage: "[NAME]",
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{}, cmd, showContext)
+				},
+			},
+			{
+				Name:      "set",
+				Usage:     "create or update a context",
+				ArgsUsage: "NAME",
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "b, bucket",
+						Usage: "the name of the s3 bucket containing the encrypted files",
+					},
+					cli.StringFlag{
+						Name:  "k, kms",
+						Usage: "the aws kms id to use when performing operations",
+					},
+					cli.StringFlag{
+						Name:  "r, region",
+						Usage: "the aws region where the resources are located",
+					},
+					cli.StringFlag{
+						Name:  "p, profile",
+						Usage: "the aws profile to use for static credentials",
+					},
+					cli.StringFlag{
+						Name:  "endpoint-url",
+						Usage: "the aws s3 endpoint to use",
+					},
+					cli.StringFlag{
+						Name:  "prefix",
+						Usage: "the prefix inside the bucket files are uploaded under by put and push, retaining their paths",
+					},
+				},
+				Action: func(cx *cli.Context) error {
+					return handleCommand(cx, []string{}, cmd, setContext)
+				},
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{}, cmd, listContexts)
+		},
+	}
+}
+
+//
+// listContexts provides a listing of the contexts
+//
+func listContexts(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	config, err := loadContextConfig(cx.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range config.names() {
+		x := config.Contexts[name]
+		marker := " "
+		if name == cmd.context {
+			marker = "*"
+		}
+		o.fields(map[string]interface{}{
+			"name":    name,
+			"active":  name == cmd.context,
+			"bucket":  x.Bucket,
+			"kms":     x.KMS,
+			"region":  x.Region,
+			"profile": x.Profile,
+		}).log("%s %-20s %-42s %s\n", marker, name, x.Bucket, x.Region)
+	}
+
+	return nil
+}
+
+//
+// useContext changes the current context in the config file
+//
+func useContext(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	if len(cx.Args()) != 1 {
+		return fmt.Errorf("you must specify the name of the context to use")
+	}
+	name := cx.Args().First()
+	filename := cx.GlobalString("config")
+
+	config, err := loadContextConfig(filename)
+	if err != nil {
+		return err
+	}
+	if _, found := config.Contexts[name]; !found {
+		return fmt.Errorf("the context: %s does not exist", name)
+	}
+	config.CurrentContext = name
+	if err := config.save(filename); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"action":  "context",
+		"context": name,
+	}).log("switched to the context: %s\n", name)
+
+	return nil
+}
+
+//
+// showContext displays the settings of the named or active context
+//
+func showContext(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	name := cmd.context
+	if len(cx.Args()) > 0 {
+		name = cx.Args().First()
+	}
+	if name == "" {
+		return fmt.Errorf("there is no active context, see context use")
+	}
+	config, err := loadContextConfig(cx.GlobalString("config"))
+	if err != nil {
+		return err
+	}
+	x, found := config.Contexts[name]
+	if !found {
+		return fmt.Errorf("the context: %s does not exist", name)
+	}
+
+	o.fields(map[string]interface{}{
+		"name":     name,
+		"active":   name == cmd.context,
+		"bucket":   x.Bucket,
+		"kms":      x.KMS,
+		"region":   x.Region,
+		"profile":  x.Profile,
+		"endpoint": x.Endpoint,
+		"prefix":   x.Prefix,
+	}).log("name:     %s\nbucket:   %s\nkms:      %s\nregion:   %s\nprofile:  %s\nendpoint: %s\nprefix:   %s\n",
+		name, x.Bucket, x.KMS, x.Region, x.Profile, x.Endpoint, x.Prefix)
+
+	return nil
+}
+
+//
+// setContext creates or updates a context, only the options given are changed
+//
+func setContext(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	if len(cx.Args()) != 1 {
+		return fmt.Errorf("you must specify the name of the context to set")
+	}
+	name := cx.Args().First()
+	filename := cx.GlobalString("config")
+
+	config, err := loadContextConfig(filename)
+	if err != nil {
+		return err
+	}
+	x, found := config.Contexts[name]
+	if !found {
+		x = &secretsContext{}
+		config.Contexts[name] = x
+	}
+	for flag, field := range map[string]*string{
+		"bucket":       &x.Bucket,
+		"kms":          &x.KMS,
+		"region":       &x.Region,
+		"profile":      &x.Profile,
+		"endpoint-url": &x.Endpoint,
+		"prefix":       &x.Prefix,
+	} {
+		if cx.IsSet(flag) {
+			*field = cx.String(flag)
+		}
+	}
+	// step: the first context becomes the current one
+	if config.CurrentContext == "" {
+		config.CurrentContext = name
+	}
+	if err := config.save(filename); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"action":  "context",
+		"context": name,
+		"created": !found,
+	}).log("successfully updated the context: %s\n", name)
+
+	return nil
+}
+
+//
+// loadContextConfig reads the config file, a missing file is an empty config
+//
+func loadContextConfig(filename string) (*contextConfig, error) {
+	config := &contextConfig{}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read the config file: %s, error: %s", filename, err)
+	}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("the config file: %s is invalid, error: %s", filename, err)
+	}
+	if config.Contexts == nil {
+		config.Contexts = make(map[string]*secretsContext, 0)
+	}
+	for name, x := range config.Contexts {
+		if x == nil {
+			config.Contexts[name] = &secretsContext{}
+		}
+	}
+
+	return config, nil
+}
+
+//
+// save writes the config file
+//
+func (r *contextConfig) save(filename string) error {
+	content, err := yaml.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, content, 0600)
+}
+
+//
+// active returns the context selected by name, else the current context, a nil context is returned
+// if neither is set
+//
+func (r *contextConfig) active(name string) (string, *secretsContext, error) {
+	if name == "" {
+		name = r.CurrentContext
+	}
+	if name == "" {
+		return "", nil, nil
+	}
+	x, found := r.Contexts[name]
+	if !found {
+		return "", nil, fmt.Errorf("the context: %s does not exist in the config file", name)
+	}
+
+	return name, x, nil
+}
+
+//
+// names returns the sorted names of the contexts
+//
+func (r *contextConfig) names() []string {
+	var list []string
+	for name := range r.Contexts {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+//
+// applyContext makes the settings of the context the defaults of the command flags taking their defaults
+// from the environment variables, a variable in the environment still takes precedence. The variables are
+// not set, so they are not passed on to the commands we execute
+//
+func applyContext(commands []cli.Command, x *secretsContext) {
+	for i := range commands {
+		for j, flag := range commands[i].Flags {
+			f, ok := flag.(cli.StringFlag)
+			if !ok {
+				continue
+			}
+			if value, found := contextVariables[f.EnvVar]; found && value(x) != "" {
+				f.Value = value(x)
+				commands[i].Flags[j] = f
+			}
+		}
+		applyContext(commands[i].Subcommands, x)
+	}
+}
+
+//
+// isMutating checks if the command changes the bucket, the help name is the program and command path
+// i.e. s3secrets buckets create
+//
+func isMutating(cx *cli.Context) bool {
+	names := strings.Fields(cx.Command.HelpName)
+	if len(names) < 2 {
+		return false
+	}
+
+	return mutatingCommands[strings.Join(names[1:], " ")]
+}
+
+//
+// contextOption returns the value of a global option, falling back to the context when the option
+// was not given on the command line or by the environment variable
+//
+func contextOption(cx *cli.Context, name, envVar, value string) string {
+	if value == "" || isOptionGiven(cx, name, envVar) {
+		return cx.GlobalString(name)
+	}
+
+	return value
+}
+
+//
+// isOptionGiven checks if a global option was given on the command line or by the environment variable
+//
+func isOptionGiven(cx *cli.Context, name, envVar string) bool {
+	if cx.GlobalIsSet(name) {
+		return true
+	}
+	if envVar != "" {
+		if _, found := os.LookupEnv(envVar); found {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+//
+// runContextCommand runs a context subcommand against the config file
+//
+func runContextCommand(t *testing.T, cmd *cliCommand, config, name string, method testMethod, args ...string) (string, error) {
+	var command cli.Command
+	for _, x := range newContextCommand(cmd).Subcommands {
+		if x.Name == name {
+			command = x
+		}
+	}
+	app := newCliApplication()
+	global := cli.NewContext(app, newTestFlagSet(t, app.Name, app.Flags, "--config", config), nil)
+	cx := cli.NewContext(app, newTestFlagSet(t, command.Name, command.Flags, args...), global)
+
+	return runTestMethod(t, cmd, cx, method)
+}
+
+func TestContextCommand(t *testing.T) {
+	cases := []struct {
+		// the subcommand to run
+		command string
+		method  testMethod
+		args    []string
+		// the active context of the command
+		active   string
+		current  string
+		contexts map[string]secretsContext
+		output   string
+		error    bool
+	}{
+		{
+			command: "set",
+			method:  setContext,
+			args:    []string{"--bucket", "b2", "--prefix", "team", "prod"},
+			current: "dev",
+			contexts: map[string]secretsContext{
+				"dev":  {Bucket: "b1", KMS: testKMS},
+				"prod": {Bucket: "b2", Prefix: "team"},
+				"test": {Bucket: testBucket},
+			},
+		},
+		{
+			command: "set",
+			method:  setContext,
+			args:    []string{"--region", "eu-west-2", "dev"},
+			current: "dev",
+			contexts: map[string]secretsContext{
+				"dev":  {Bucket: "b1", KMS: testKMS, Region: "eu-west-2"},
+				"test": {Bucket: testBucket},
+			},
+		},
+		{
+			command: "set",
+			method:  setContext,
+			error:   true,
+		},
+		{
+			command: "use",
+			method:  useContext,
+			args:    []string{"test"},
+			current: "test",
+			contexts: map[string]secretsContext{
+				"dev":  {Bucket: "b1", KMS: testKMS},
+				"test": {Bucket: testBucket},
+			},
+		},
+		{
+			command: "use",
+			method:  useContext,
+			args:    []string{"missing"},
+			error:   true,
+		},
+		{
+			command: "show",
+			method:  showContext,
+			active:  "dev",
+			current: "dev",
+			contexts: map[string]secretsContext{
+				"dev":  {Bucket: "b1", KMS: testKMS},
+				"test": {Bucket: testBucket},
+			},
+			output: "name:     dev\nbucket:   b1\nkms:      alias/test\nregion:   \nprofile:  \nendpoint: \nprefix:   \n",
+		},
+		{
+			command: "show",
+			method:  showContext,
+			error:   true,
+		},
+		{
+			command: "show",
+			method:  showContext,
+			args:    []string{"missing"},
+			error:   true,
+		},
+		{
+			command: "ls",
+			method:  listContexts,
+			active:  "dev",
+			current: "dev",
+			contexts: map[string]secretsContext{
+				"dev":  {Bucket: "b1", KMS: testKMS},
+				"test": {Bucket: testBucket},
+			},
+			output: "* dev                  b1                                         \n" +
+				"  test                 test                                       \n",
+		},
+	}
+	for i, c := range cases {
+		dir, cleanup := newTestDir(t, nil)
+		config := filepath.Join(dir, "config")
+		if err := (&contextConfig{
+			CurrentContext: "dev",
+			Contexts: map[string]*secretsContext{
+				"dev":  {Bucket: "b1", KMS: testKMS},
+				"test": {Bucket: testBucket},
+			},
+		}).save(config); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := newTestCommand(t)
+		cmd.context = c.active
+		output, err := runContextCommand(t, cmd, config, c.command, c.method, c.args...)
+		settings, loadErr := loadContextConfig(config)
+		cleanup()
+		if loadErr != nil {
+			t.Fatal(loadErr)
+		}
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if c.output != "" && output != c.output {
+			t.Errorf("case %d: expected the output: %q, got: %q", i, c.output, output)
+		}
+		if settings.CurrentContext != c.current {
+			t.Errorf("case %d: expected the current context: %s, got: %s", i, c.current, settings.CurrentContext)
+		}
+		contexts := make(map[string]secretsContext, 0)
+		for name, x := range settings.Contexts {
+			contexts[name] = *x
+		}
+		if !reflect.DeepEqual(contexts, c.contexts) {
+			t.Errorf("case %d: expected the contexts: %v, got: %v", i, c.contexts, contexts)
+		}
+	}
+}
+
+func TestContextBootstrap(t *testing.T) {
+	dir, cleanup := newTestDir(t, nil)
+	defer cleanup()
+	config := filepath.Join(dir, "config")
+	if err := (&contextConfig{
+		CurrentContext: "dev",
+		Contexts: map[string]*secretsContext{
+			"dev": {Bucket: "b1", KMS: testKMS, Prefix: "team"},
+		},
+	}).save(config); err != nil {
+		t.Fatal(err)
+	}
+	// step: the variables must not be taken from the environment of the test
+	for name := range contextVariables {
+		if value, found := os.LookupEnv(name); found {
+			defer os.Setenv(name, value)
+			os.Unsetenv(name)
+		}
+	}
+
+	cases := []struct {
+		args []string
+		// the bucket the list command defaults to, and the environment variable if any
+		bucket      string
+		environment string
+		error       bool
+	}{
+		{args: []string{"--context", "missing", "context", "ls"}},
+		{args: []string{"--context", "missing", "context", "use", "dev"}},
+		{args: []string{"--context", "missing", "list"}, error: true},
+		{args: []string{"list"}, bucket: "b1"},
+		{args: []string{"list"}, bucket: "env", environment: "env"},
+	}
+	for i, c := range cases {
+		if c.environment != "" {
+			os.Setenv("AWS_S3_BUCKET", c.environment)
+		}
+		app := newCliApplication()
+		args := append([]string{"--config", config, "--local-dir", filepath.Join(dir, "local")}, c.args...)
+		err := app.Before(cli.NewContext(app, newTestFlagSet(t, app.Name, app.Flags, args...), nil))
+		// step: the context is applied to the flags rather than the environment
+		variable, _ := os.LookupEnv("AWS_S3_BUCKET")
+		var bucket string
+		if command := app.Command("list"); command != nil {
+			bucket = newTestContext(t, *command).String("bucket")
+		}
+		os.Unsetenv("AWS_S3_BUCKET")
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if variable != c.environment {
+			t.Errorf("case %d: expected the bucket variable: %q, got: %q", i, c.environment, variable)
+		}
+		if c.bucket != "" && bucket != c.bucket {
+			t.Errorf("case %d: expected the bucket: %s, got: %s", i, c.bucket, bucket)
+		}
+	}
+}
+
+func TestApplyContext(t *testing.T) {
+	commands := []cli.Command{
+		newPutCommand(nil),
+		{Name: "parent", Subcommands: []cli.Command{newGetCommand(nil)}},
+	}
+	applyContext(commands, &secretsContext{Bucket: "b1", KMS: testKMS, Prefix: "team"})
+
+	expected := map[string]string{"bucket": "b1", "kms": testKMS, "prefix": "team"}
+	for name, value := range expected {
+		if v := newTestContext(t, commands[0]).String(name); v != value {
+			t.Errorf("expected the put option: %s to default to: %s, got: %s", name, value, v)
+		}
+	}
+	if v := newTestContext(t, commands[1].Subcommands[0]).String("bucket"); v != "b1" {
+		t.Errorf("expected the get option: bucket to default to: b1, got: %s", v)
+	}
+	if v := newTestContext(t, commands[0], "--bucket", "b2").String("bucket"); v != "b2" {
+		t.Errorf("expected the option given to take precedence, got: %s", v)
+	}
+}
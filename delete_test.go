@@ -0,0 +1,73 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDeleteFile(t *testing.T) {
+	cases := []struct {
+		args  []string
+		keys  []string
+		error bool
+	}{
+		{
+			args: []string{"--bucket", testBucket, "a.txt"},
+			keys: []string{"dir/b.txt"},
+		},
+		{
+			args: []string{"--bucket", testBucket, "a.txt", "dir/b.txt"},
+		},
+		{
+			args: []string{"--bucket", testBucket, "missing.txt"},
+			keys: []string{"a.txt", "dir/b.txt"},
+		},
+		{
+			args: []string{"--bucket", testBucket, "dir/"},
+			keys: []string{"a.txt", "dir/b.txt"},
+		},
+		{
+			args:  []string{"--bucket", testBucket},
+			error: true,
+		},
+		{
+			args:  []string{"--bucket", "missing", "a.txt"},
+			error: true,
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		putTestFile(t, cmd, "a.txt", "a", false)
+		putTestFile(t, cmd, "dir/b.txt", "b", true)
+
+		_, err := runTestCommand(t, cmd, newDeleteCommand(cmd), deleteFile, c.args...)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if keys := testKeys(t, cmd); !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("case %d: expected the keys: %v, got: %v", i, c.keys, keys)
+		}
+	}
+}
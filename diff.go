@@ -0,0 +1,486 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// diffContext is the number of unchanged lines shown around the changes
+const diffContext = 3
+
+//
+// newDiffCommand creates a new diff command
+//
+func newDiffCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "diff",
+		Usage:     "compares one or more local files with their counterparts in the bucket",
+		ArgsUsage: "PATH...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			keyPathFlag,
+			keyFlattenFlag,
+			keyPrefixFlag,
+			cli.BoolFlag{
+				Name:  "redact",
+				Usage: "do not show the content of the changes, only the line numbers and a hash of the lines",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, diffFiles)
+		},
+	}
+}
+
+// diffLine is a single line of a diff
+type diffLine struct {
+	// the operation, either ' ', '-' or '+'
+	op byte
+	// the content of the line, including any line ending
+	text string
+	// the index of the line in the old and new content
+	oldIndex, newIndex int
+}
+
+//
+// diffFiles compares the local files with the files in the bucket, the keys are resolved as put would
+//
+func diffFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	redact := cx.Bool("redact")
+
+	keyName, err := keyNameResolver(cx)
+	if err != nil {
+		return err
+	}
+	if len(cx.Args()) <= 0 {
+		return fmt.Errorf("you have not specified any files to compare")
+	}
+
+	summary := map[string]int{"new": 0, "modified": 0, "unchanged": 0}
+	for _, p := range getPaths(cx) {
+		files, err := expandFiles(p)
+		if err != nil {
+			return fmt.Errorf("failed to process path: %s, error: %s", p, err)
+		}
+		for _, filename := range files {
+			keyName := keyName(filename)
+
+			local, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			// step: retrieve the remote copy, a missing key is a new file
+			status := "modified"
+			source := fmt.Sprintf("s3://%s/%s", bucket, keyName)
+			remote, err := cmd.getFile(bucket, keyName)
+			if err != nil {
+				if !isNoSuchKey(err) {
+					return fmt.Errorf("unable to retrieve the file: %s, error: %s", keyName, err)
+				}
+				status = "new"
+				source = "/dev/null"
+			}
+			if status == "modified" && bytes.Equal(local, remote) {
+				status = "unchanged"
+			}
+			summary[status]++
+
+			lines := computeDiff(splitLines(string(remote)), splitLines(string(local)))
+			added, removed := countChanges(lines)
+
+			o.fields(map[string]interface{}{
+				"action":  "diff",
+				"bucket":  bucket,
+				"key":     keyName,
+				"path":    filename,
+				"status":  status,
+				"added":   added,
+				"removed": removed,
+			}).log("%s", formatDiff(source, filename, lines, redact))
+		}
+	}
+
+	o.fields(map[string]interface{}{
+		"action":    "diff-summary",
+		"bucket":    bucket,
+		"new":       summary["new"],
+		"modified":  summary["modified"],
+		"unchanged": summary["unchanged"],
+	}).log("%d new, %d modified, %d unchanged\n", summary["new"], summary["modified"], summary["unchanged"])
+
+	return nil
+}
+
+//
+// splitLines splits the content into lines, retaining the line endings
+//
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+//
+// computeDiff calculates the edits required to turn the old lines into the new lines from the longest
+// common subsequence of the two
+//
+func computeDiff(a, b []string) []diffLine {
+	d := newDiffer(a, b)
+	d.compare(0, len(a), 0, len(b))
+
+	// step: walk the matching lines, the lines between them are removed from a and added from b
+	var lines []diffLine
+	i, j := 0, 0
+	for _, x := range append(d.matches, [2]int{len(a), len(b)}) {
+		for ; i < x[0]; i++ {
+			lines = append(lines, diffLine{op: '-', text: a[i], oldIndex: i, newIndex: j})
+		}
+		for ; j < x[1]; j++ {
+			lines = append(lines, diffLine{op: '+', text: b[j], oldIndex: i, newIndex: j})
+		}
+		if i < len(a) {
+			lines = append(lines, diffLine{op: ' ', text: a[i], oldIndex: i, newIndex: j})
+			i++
+			j++
+		}
+	}
+
+	return lines
+}
+
+// differ finds the longest common subsequence of two sets of lines in linear space, using the divide
+// and conquer form of the myers algorithm
+type differ struct {
+	// the old and new lines
+	a, b []string
+	// the furthest reaching paths of the forward and reverse searches, indexed by diagonal
+	forward, reverse []int
+	// the indexes of the lines matched in a and b, in order
+	matches [][2]int
+}
+
+//
+// newDiffer creates a differ for the lines
+//
+func newDiffer(a, b []string) *differ {
+	size := len(a) + len(b) + 4
+
+	return &differ{a: a, b: b, forward: make([]int, size), reverse: make([]int, size)}
+}
+
+//
+// compare records the matching lines of a[aLo:aHi] and b[bLo:bHi]
+//
+func (r *differ) compare(aLo, aHi, bLo, bHi int) {
+	// step: match the common prefix and suffix
+	for aLo < aHi && bLo < bHi && r.a[aLo] == r.b[bLo] {
+		r.matches = append(r.matches, [2]int{aLo, bLo})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi && bLo < bHi && r.a[aHi-1] == r.b[bHi-1] {
+		aHi--
+		bHi--
+		suffix++
+	}
+
+	// step: split on the middle snake of the remainder and compare either side of it
+	if aLo < aHi && bLo < bHi {
+		x, y, u, v := r.middleSnake(aLo, aHi, bLo, bHi)
+		r.compare(aLo, x, bLo, y)
+		for ; x < u; x, y = x+1, y+1 {
+			r.matches = append(r.matches, [2]int{x, y})
+		}
+		r.compare(u, aHi, v, bHi)
+	}
+	for i := 0; i < suffix; i++ {
+		r.matches = append(r.matches, [2]int{aHi + i, bHi + i})
+	}
+}
+
+//
+// middleSnake searches from both ends of a[aLo:aHi] and b[bLo:bHi] until the paths overlap, returning the
+// start and end of the snake in the middle of an optimal path
+//
+func (r *differ) middleSnake(aLo, aHi, bLo, bHi int) (int, int, int, int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	offset := (n+m+1)/2 + 1
+	r.forward[offset+1], r.reverse[offset+1] = 0, 0
+
+	for d := 0; d <= (n+m+1)/2; d++ {
+		// step: extend the forward paths, checking for an overlap with the reverse paths of the last round
+		for k := -d; k <= d; k += 2 {
+			x := r.forward[offset+k+1]
+			if k != -d && (k == d || r.forward[offset+k-1] >= r.forward[offset+k+1]) {
+				x = r.forward[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && r.a[aLo+x] == r.b[bLo+y] {
+				x++
+				y++
+			}
+			r.forward[offset+k] = x
+			if rk := delta - k; odd && rk >= -(d-1) && rk <= d-1 && x+r.reverse[offset+rk] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y
+			}
+		}
+		// step: extend the reverse paths, checking for an overlap with the forward paths of this round
+		for k := -d; k <= d; k += 2 {
+			x := r.reverse[offset+k+1]
+			if k != -d && (k == d || r.reverse[offset+k-1] >= r.reverse[offset+k+1]) {
+				x = r.reverse[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && r.a[aHi-1-x] == r.b[bHi-1-y] {
+				x++
+				y++
+			}
+			r.reverse[offset+k] = x
+			if fk := delta - k; !odd && fk >= -d && fk <= d && x+r.forward[offset+fk] >= n {
+				return aHi - x, bHi - y, aHi - startX, bHi - startY
+			}
+		}
+	}
+
+	// step: unreachable, the paths always overlap by the time half the edits are made
+	return aLo, bLo, aLo, bLo
+}
+
+//
+// countChanges returns the number of lines added and removed
+//
+func countChanges(lines []diffLine) (int, int) {
+	var added, removed int
+	for _, x := range lines {
+		switch x.op {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+
+	return added, removed
+}
+
+//
+// formatDiff produces a unified diff of the changes, when redacted only the line numbers and a hash of the
+// changed lines are shown
+//
+func formatDiff(source, destination string, lines []diffLine, redact bool) string {
+	hunks := diffHunks(lines, diffContext)
+	if len(hunks) <= 0 {
+		return ""
+	}
+
+	b := new(bytes.Buffer)
+	fmt.Fprintf(b, "--- %s\n+++ %s\n", source, destination)
+	for _, hunk := range hunks {
+		oldStart, newStart := hunk[0].oldIndex+1, hunk[0].newIndex+1
+		var oldCount, newCount int
+		for _, x := range hunk {
+			if x.op != '+' {
+				oldCount++
+			}
+			if x.op != '-' {
+				newCount++
+			}
+		}
+		// step: an empty range starts at the line before, as per diff
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		fmt.Fprintf(b, "@@ -%d,%d +%d,%d @@\n", oldStart, oldCount, newStart, newCount)
+
+		for _, x := range hunk {
+			switch redact {
+			case true:
+				if x.op == ' ' {
+					continue
+				}
+				line := x.oldIndex + 1
+				if x.op == '+' {
+					line = x.newIndex + 1
+				}
+				checksum := sha256.Sum256([]byte(x.text))
+				fmt.Fprintf(b, "%c%d sha256:%s\n", x.op, line, hex.EncodeToString(checksum[:])[:16])
+			default:
+				fmt.Fprintf(b, "%c%s", x.op, strings.TrimSuffix(x.text, "\n"))
+				if !strings.HasSuffix(x.text, "\n") {
+					fmt.Fprintf(b, "\n\\ No newline at end of file")
+				}
+				fmt.Fprintf(b, "\n")
+			}
+		}
+	}
+
+	return b.String()
+}
+
+//
+// diffHunks groups the changes into hunks with the given number of unchanged lines around them, merging
+// any hunks which would overlap
+//
+func diffHunks(lines []diffLine, context int) [][]diffLine {
+	var hunks [][]diffLine
+
+	end := 0
+	for i := 0; i < len(lines); {
+		if lines[i].op == ' ' {
+			i++
+			continue
+		}
+		start := i - context
+		if start < end {
+			start = end
+		}
+		// step: find the last change within reach of the context
+		last := i
+		for j := i; j < len(lines) && j-last <= 2*context; j++ {
+			if lines[j].op != ' ' {
+				last = j
+			}
+		}
+		end = last + context + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+		hunks = append(hunks, lines[start:end])
+		i = end
+	}
+
+	return hunks
+}
+
+//
+// mergeLines performs a three way merge of the changes made to the base in ours and theirs, any changes
+// which overlap are marked as conflicts in the style of diff3
+//
+func mergeLines(base, ours, theirs []string) ([]string, bool) {
+	oursMatch := matchLines(base, ours)
+	theirsMatch := matchLines(base, theirs)
+
+	var merged []string
+	var conflict bool
+	b, o, t := 0, 0, 0
+	for {
+		// step: find the next line of the base unchanged in both
+		i := b
+		for i < len(base) && (oursMatch[i] < 0 || theirsMatch[i] < 0) {
+			i++
+		}
+		oursEnd, theirsEnd := len(ours), len(theirs)
+		if i < len(base) {
+			oursEnd, theirsEnd = oursMatch[i], theirsMatch[i]
+		}
+
+		// step: resolve the changes made before it
+		baseChunk, oursChunk, theirsChunk := base[b:i], ours[o:oursEnd], theirs[t:theirsEnd]
+		switch {
+		case equalLines(oursChunk, baseChunk):
+			merged = append(merged, theirsChunk...)
+		case equalLines(theirsChunk, baseChunk), equalLines(oursChunk, theirsChunk):
+			merged = append(merged, oursChunk...)
+		default:
+			conflict = true
+			merged = append(merged, "<<<<<<< local\n")
+			merged = append(merged, terminateLines(oursChunk)...)
+			merged = append(merged, "=======\n")
+			merged = append(merged, terminateLines(theirsChunk)...)
+			merged = append(merged, ">>>>>>> bucket\n")
+		}
+		if i >= len(base) {
+			break
+		}
+		merged = append(merged, base[i])
+		b, o, t = i+1, oursEnd+1, theirsEnd+1
+	}
+
+	return merged, conflict
+}
+
+//
+// matchLines returns the index of each line of a in b, or -1 if the line was removed
+//
+func matchLines(a, b []string) []int {
+	matches := make([]int, len(a))
+	for i := range matches {
+		matches[i] = -1
+	}
+	for _, x := range computeDiff(a, b) {
+		if x.op == ' ' {
+			matches[x.oldIndex] = x.newIndex
+		}
+	}
+
+	return matches
+}
+
+//
+// equalLines checks if the lines are the same
+//
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+// terminateLines ensures the last line ends with a newline, so the conflict markers are on their own lines
+//
+func terminateLines(lines []string) []string {
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		terminated := append([]string{}, lines...)
+		terminated[len(terminated)-1] += "\n"
+		return terminated
+	}
+
+	return lines
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffFiles(t *testing.T) {
+	dir, cleanup := newTestDir(t, map[string]string{
+		"new.env":       "A=1\n",
+		"modified.env":  "A=1\nB=3\nC=3\n",
+		"unchanged.env": "A=1\n",
+	})
+	defer cleanup()
+
+	cases := []struct {
+		args     []string
+		expected string
+		error    bool
+	}{
+		{
+			args: []string{"--flatten", dir + "/new.env"},
+			expected: "--- /dev/null\n+++ " + dir + "/new.env\n@@ -0,0 +1,1 @@\n+A=1\n" +
+				"1 new, 0 modified, 0 unchanged\n",
+		},
+		{
+			args: []string{"--flatten", dir + "/modified.env"},
+			expected: "--- s3://test/modified.env\n+++ " + dir + "/modified.env\n@@ -1,3 +1,3 @@\n A=1\n-B=2\n+B=3\n C=3\n" +
+				"0 new, 1 modified, 0 unchanged\n",
+		},
+		{
+			args:     []string{"--flatten", dir + "/unchanged.env"},
+			expected: "0 new, 0 modified, 1 unchanged\n",
+		},
+		{
+			args: []string{"--flatten", "--redact", dir + "/modified.env"},
+			expected: "--- s3://test/modified.env\n+++ " + dir + "/modified.env\n@@ -1,3 +1,3 @@\n" +
+				"-2 sha256:36aab58d03c7da74\n+2 sha256:ce51e7c229b0cea0\n" +
+				"0 new, 1 modified, 0 unchanged\n",
+		},
+		{
+			args:     []string{"--path", "team", dir + "/unchanged.env"},
+			expected: "0 new, 0 modified, 1 unchanged\n",
+		},
+		{
+			args:  []string{"--flatten", "--path", "team", dir},
+			error: true,
+		},
+		{
+			args:  []string{"--flatten"},
+			error: true,
+		},
+		{
+			args:  []string{"--flatten", dir + "/missing.env"},
+			error: true,
+		},
+	}
+	cmd := newTestCommand(t)
+	putTestFile(t, cmd, "modified.env", "A=1\nB=2\nC=3\n", false)
+	putTestFile(t, cmd, "unchanged.env", "A=1\n", true)
+	putTestFile(t, cmd, "team/unchanged.env", "A=1\n", false)
+
+	for i, c := range cases {
+		args := append([]string{"--bucket", testBucket}, c.args...)
+		output, err := runTestCommand(t, cmd, newDiffCommand(cmd), diffFiles, args...)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if output != c.expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, output)
+		}
+	}
+}
+
+func TestComputeDiff(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected string
+	}{
+		{a: "", b: "", expected: ""},
+		{a: "a\nb\n", b: "a\nb\n", expected: " a\n b\n"},
+		{a: "", b: "a\n", expected: "+a\n"},
+		{a: "a\n", b: "", expected: "-a\n"},
+		{a: "a\nb\nc\n", b: "a\nc\n", expected: " a\n-b\n c\n"},
+		{a: "a\nc\n", b: "a\nb\nc\n", expected: " a\n+b\n c\n"},
+		{a: "a\nb\nc\n", b: "c\nb\na\n", expected: "-a\n-b\n c\n+b\n+a\n"},
+		{a: "a\nb", b: "a\nb\n", expected: " a\n-b+b\n"},
+	}
+	for i, c := range cases {
+		var ops []string
+		for _, x := range computeDiff(splitLines(c.a), splitLines(c.b)) {
+			ops = append(ops, string(x.op)+x.text)
+		}
+		if diff := strings.Join(ops, ""); diff != c.expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, diff)
+		}
+	}
+}
+
+func TestDiffHunks(t *testing.T) {
+	cases := []struct {
+		a, b    string
+		context int
+		hunks   []int
+	}{
+		{a: "a\n", b: "a\n", context: 3},
+		{a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", b: "1\n2\n3\n4\n5\n6\n7\n8\n9\nx\n", context: 3, hunks: []int{5}},
+		{a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", b: "x\n2\n3\n4\n5\n6\n7\n8\n9\nx\n", context: 1, hunks: []int{3, 3}},
+		{a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", b: "x\n2\n3\n4\n5\n6\n7\n8\n9\nx\n", context: 3, hunks: []int{5, 5}},
+		{a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", b: "x\n2\n3\n4\n5\n6\n7\n8\n9\nx\n", context: 4, hunks: []int{6, 6}},
+		{a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", b: "x\n2\n3\n4\n5\n6\n7\n8\n9\nx\n", context: 5, hunks: []int{12}},
+	}
+	for i, c := range cases {
+		var sizes []int
+		for _, x := range diffHunks(computeDiff(splitLines(c.a), splitLines(c.b)), c.context) {
+			sizes = append(sizes, len(x))
+		}
+		if !reflect.DeepEqual(sizes, c.hunks) {
+			t.Errorf("case %d: expected the hunks: %v, got: %v", i, c.hunks, sizes)
+		}
+	}
+}
+
+func TestMergeLines(t *testing.T) {
+	cases := []struct {
+		base, ours, theirs string
+		expected           string
+		conflict           bool
+	}{
+		{
+			base: "a\nb\nc\n", ours: "a\nb\nc\n", theirs: "a\nb\nc\n",
+			expected: "a\nb\nc\n",
+		},
+		{
+			base: "a\nb\nc\n", ours: "x\nb\nc\n", theirs: "a\nb\ny\n",
+			expected: "x\nb\ny\n",
+		},
+		{
+			base: "a\nb\nc\n", ours: "a\nx\nc\n", theirs: "a\nx\nc\n",
+			expected: "a\nx\nc\n",
+		},
+		{
+			base: "a\nb\nc\n", ours: "a\nx\nc\n", theirs: "a\ny\nc\n",
+			conflict: true,
+		},
+	}
+	for i, c := range cases {
+		lines, conflict := mergeLines(splitLines(c.base), splitLines(c.ours), splitLines(c.theirs))
+		if conflict != c.conflict {
+			t.Errorf("case %d: expected conflict: %t, got: %t", i, c.conflict, conflict)
+			continue
+		}
+		if merged := strings.Join(lines, ""); !c.conflict && merged != c.expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, merged)
+		}
+	}
+}
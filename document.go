@@ -0,0 +1,374 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// jsonIndentRegex finds the indentation of the first nested line of a json document
+var jsonIndentRegex = regexp.MustCompile(`\n([ \t]+)\S`)
+
+// jsonHTMLEscapes are the escape sequences json.Marshal uses for the html characters
+var jsonHTMLEscapes = map[string]byte{`\u003c`: '<', `\u003e`: '>', `\u0026`: '&'}
+
+// documentField is a dotted field and the value to set it to
+type documentField struct {
+	name  string
+	value interface{}
+}
+
+//
+// documentFormat returns the format of a structured document, either json, yaml or env, taken from the
+// extension of the key, else sniffed from the content
+//
+func documentFormat(key string, content []byte) string {
+	switch strings.ToLower(filepath.Ext(key)) {
+	case ".json":
+		return "json"
+	case ".yml", ".yaml":
+		return "yaml"
+	case ".env":
+		return "env"
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		return "json"
+	}
+
+	return "yaml"
+}
+
+//
+// decodeDocument decodes a json, yaml or dotenv document for field lookups
+//
+func decodeDocument(key string, content []byte) (interface{}, error) {
+	var document interface{}
+
+	switch documentFormat(key, content) {
+	case "json":
+		if err := decodeJSON(content, &document); err != nil {
+			return nil, fmt.Errorf("the file: %s is not valid json, error: %s", key, err)
+		}
+	case "env":
+		variables, err := parseEnvironment(content)
+		if err != nil {
+			return nil, fmt.Errorf("the file: %s is not a valid environment file, error: %s", key, err)
+		}
+		values := make(map[string]interface{}, len(variables))
+		for k, v := range variables {
+			values[k] = v
+		}
+		document = values
+	default:
+		if err := yaml.Unmarshal(content, &document); err != nil {
+			return nil, fmt.Errorf("the file: %s is not valid yaml, error: %s", key, err)
+		}
+		document = normalizeYAML(document)
+	}
+
+	return document, nil
+}
+
+//
+// setDocumentFields updates the dotted fields in a json or yaml document, retaining the format and order
+// of the keys. Note, any comments in a yaml document are lost, see hasYAMLComments.
+//
+func setDocumentFields(key string, content []byte, fields []documentField) ([]byte, error) {
+	format := documentFormat(key, content)
+
+	// step: decode the document, retaining the order of the keys
+	var document interface{}
+	switch format {
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		value, err := decodeOrderedJSON(decoder)
+		if err != nil {
+			return nil, fmt.Errorf("the file: %s is not valid json, error: %s", key, err)
+		}
+		document = value
+	case "yaml":
+		var ordered yaml.MapSlice
+		if err := yaml.Unmarshal(content, &ordered); err != nil {
+			return nil, fmt.Errorf("the file: %s is not valid yaml, error: %s", key, err)
+		}
+		document = ordered
+	default:
+		return nil, fmt.Errorf("the file: %s is not a json or yaml document", key)
+	}
+
+	// step: update the fields in order
+	for _, x := range fields {
+		value := x.value
+		if format == "yaml" {
+			value = yamlNumbers(value)
+		}
+		updated, err := setField(document, strings.Split(x.name, "."), value, x.name)
+		if err != nil {
+			return nil, err
+		}
+		document = updated
+	}
+
+	// step: encode the document in the original format
+	if format == "yaml" {
+		return yaml.Marshal(document)
+	}
+	encoded := new(bytes.Buffer)
+	if err := encodeOrderedJSON(encoded, document); err != nil {
+		return nil, err
+	}
+	if matches := jsonIndentRegex.FindSubmatch(content); matches != nil {
+		indented := new(bytes.Buffer)
+		if err := json.Indent(indented, encoded.Bytes(), "", string(matches[1])); err != nil {
+			return nil, err
+		}
+		encoded = indented
+	}
+	if bytes.HasSuffix(content, []byte("\n")) {
+		encoded.WriteString("\n")
+	}
+
+	return encoded.Bytes(), nil
+}
+
+//
+// setField sets the value of the field within the ordered document, creating any missing maps
+//
+func setField(node interface{}, names []string, value interface{}, field string) (interface{}, error) {
+	if len(names) <= 0 {
+		return value, nil
+	}
+	name := names[0]
+
+	switch v := node.(type) {
+	case nil:
+		child, err := setField(nil, names[1:], value, field)
+		if err != nil {
+			return nil, err
+		}
+		return yaml.MapSlice{{Key: name, Value: child}}, nil
+	case yaml.MapSlice:
+		for i, item := range v {
+			if fmt.Sprintf("%v", item.Key) == name {
+				child, err := setField(item.Value, names[1:], value, field)
+				if err != nil {
+					return nil, err
+				}
+				v[i].Value = child
+				return v, nil
+			}
+		}
+		child, err := setField(nil, names[1:], value, field)
+		if err != nil {
+			return nil, err
+		}
+		return append(v, yaml.MapItem{Key: name, Value: child}), nil
+	case []interface{}:
+		index, err := strconv.Atoi(name)
+		if err != nil || index < 0 || index >= len(v) {
+			return nil, fmt.Errorf("field: %s has an invalid index: %s", field, name)
+		}
+		child, err := setField(v[index], names[1:], value, field)
+		if err != nil {
+			return nil, err
+		}
+		v[index] = child
+		return v, nil
+	}
+
+	return nil, fmt.Errorf("field: %s cannot be set, the parent of %s is not a map or list", field, name)
+}
+
+//
+// decodeOrderedJSON decodes a json value, representing objects as ordered yaml maps
+//
+func decodeOrderedJSON(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, found := token.(json.Delim)
+	if !found {
+		return token, nil
+	}
+
+	switch delim {
+	case '{':
+		document := yaml.MapSlice{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			document = append(document, yaml.MapItem{Key: key, Value: value})
+		}
+		_, err := decoder.Token()
+		return document, err
+	case '[':
+		list := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := decoder.Token()
+		return list, err
+	}
+
+	return nil, fmt.Errorf("unexpected delimiter: %s", delim)
+}
+
+//
+// encodeOrderedJSON encodes a document holding ordered yaml maps as compact json
+//
+func encodeOrderedJSON(b *bytes.Buffer, v interface{}) error {
+	switch value := v.(type) {
+	case yaml.MapSlice:
+		b.WriteString("{")
+		for i, item := range value {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			key, err := marshalJSON(fmt.Sprintf("%v", item.Key))
+			if err != nil {
+				return err
+			}
+			b.Write(key)
+			b.WriteString(":")
+			if err := encodeOrderedJSON(b, item.Value); err != nil {
+				return err
+			}
+		}
+		b.WriteString("}")
+	case []interface{}:
+		b.WriteString("[")
+		for i, x := range value {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			if err := encodeOrderedJSON(b, x); err != nil {
+				return err
+			}
+		}
+		b.WriteString("]")
+	default:
+		encoded, err := marshalJSON(value)
+		if err != nil {
+			return err
+		}
+		b.Write(encoded)
+	}
+
+	return nil
+}
+
+//
+// marshalJSON encodes the value as json without the html escaping of json.Marshal, i.e. < as \u003c, as
+// the encoder option to disable it is not available in go 1.6
+//
+func marshalJSON(v interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	b := new(bytes.Buffer)
+	for i := 0; i < len(encoded); i++ {
+		if encoded[i] != '\\' {
+			b.WriteByte(encoded[i])
+			continue
+		}
+		// step: copy the escape sequence, replacing those of the html characters
+		if i+6 <= len(encoded) {
+			if c, found := jsonHTMLEscapes[string(encoded[i:i+6])]; found {
+				b.WriteByte(c)
+				i += 5
+				continue
+			}
+		}
+		b.Write(encoded[i : i+2])
+		i++
+	}
+
+	return b.Bytes(), nil
+}
+
+//
+// yamlNumbers converts the json numbers in a value to integers or floats, which would otherwise be
+// encoded as yaml strings
+//
+func yamlNumbers(v interface{}) interface{} {
+	switch value := v.(type) {
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i
+		}
+		if f, err := value.Float64(); err == nil {
+			return f
+		}
+	case yaml.MapSlice:
+		for i := range value {
+			value[i].Value = yamlNumbers(value[i].Value)
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = yamlNumbers(value[i])
+		}
+	}
+
+	return v
+}
+
+//
+// hasYAMLComments checks if a yaml document contains any comments, i.e. a # at the start of a line or
+// following whitespace outside of a quoted string
+//
+func hasYAMLComments(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		var quote rune
+		previous := ' '
+		for _, c := range line {
+			switch {
+			case quote == '"' && previous == '\\':
+				// an escaped character within a double quoted string
+				c = 0
+			case quote != 0 && c == quote:
+				quote = 0
+			case quote == 0 && (c == '"' || c == '\'') && strings.ContainsRune(" \t:[{,-", previous):
+				quote = c
+			case quote == 0 && c == '#' && (previous == ' ' || previous == '\t'):
+				return true
+			}
+			previous = c
+		}
+	}
+
+	return false
+}
@@ -16,11 +16,16 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/urfave/cli"
 )
 
@@ -40,6 +45,11 @@ func newEditCommand(cmd *cliCommand) cli.Command {
 				Value:  "vim",
 				EnvVar: "EDITOR",
 			},
+			cli.StringFlag{
+				Name:  "validate",
+				Usage: "validate the content before uploading, auto selects by extension, none disables or one of json, yaml, env, pem, toml",
+				Value: "auto",
+			},
 		},
 		Action: func(cx *cli.Context) error {
 			return handleCommand(cx, []string{"l:bucket:s"}, cmd, editFile)
@@ -53,29 +63,56 @@ func newEditCommand(cmd *cliCommand) cli.Command {
 func editFile(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	bucket := cx.String("bucket")
 	editor := cx.String("editor")
+	validate := cx.String("validate")
+	prompt := bufio.NewReader(os.Stdin)
 
 	for _, key := range cx.Args() {
-		// step: retrieve the head metadata
-		metadata, err := cmd.getFileMetadata(key, bucket)
+		// step: select the validator of the file before any editing
+		check, err := selectValidator(validate, key)
 		if err != nil {
 			return err
 		}
 
-		// step: attempt to retrieve the data
-		content, err := cmd.getFile(bucket, key)
+		// step: retrieve the data, along with the etag and encryption of the version retrieved
+		content, resp, err := cmd.getFileRevision(bucket, key)
 		if err != nil {
-			return fmt.Errorf("unable to retrieve keythe file: %s, error: %s", key, err)
+			return fmt.Errorf("unable to retrieve the file: %s, error: %s", key, err)
 		}
+		kmsID, envelope := retrievedEncryption(resp)
+		if kmsID == "" {
+			return fmt.Errorf("unable to determine the kms key used to encrypt the file: %s", key)
+		}
+		etag := aws.StringValue(resp.ETag)
 
-		// step: write the file to the
-		path, err := inlineEdit(content, editor)
+		// step: edit the content
+		edited, err := inlineEdit(key, content, editor)
 		if err != nil {
 			return fmt.Errorf("unable to edit the file: %s, error: %s", key, err)
 		}
+		if bytes.Equal(edited, content) {
+			o.fields(map[string]interface{}{
+				"action": "edit",
+				"key":    key,
+				"bucket": bucket,
+			}).log("no changes made to the file: s3://%s/%s, skipping the upload\n", bucket, key)
+			continue
+		}
+		if edited, err = validateEdit(key, check, editor, edited, prompt); err != nil {
+			return err
+		}
+
+		// step: ensure the file has not been changed in the bucket while we were editing, a merge
+		// may result in invalid content so we validate again
+		edited, err = resolveConflicts(cmd, bucket, key, editor, etag, content, edited, prompt)
+		if err != nil {
+			return err
+		}
+		if edited, err = validateEdit(key, check, editor, edited, prompt); err != nil {
+			return err
+		}
 
 		// step: upload the content to bucket
-		if err := cmd.putFile(bucket, key, path, *metadata.SSEKMSKeyId); err != nil {
-			os.Remove(path)
+		if err := cmd.uploadFile(bucket, key, bytes.NewReader(edited), kmsID, envelope); err != nil {
 			return err
 		}
 
@@ -85,38 +122,143 @@ func editFile(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 			"key":    key,
 			"bucket": bucket,
 		}).log("successfully edited and uploaded file: s3://%s/%s\n", bucket, key)
-
-		os.Remove(path)
 	}
 
 	return nil
 }
 
 //
-// inlineEdit performs an inline edit of the file
+// resolveConflicts checks the etag of the file in the bucket is the one we edited, else prompts to merge
+// our changes with the newer version, overwrite it or abort. Note, the check is not atomic with the upload.
+//
+func resolveConflicts(cmd *cliCommand, bucket, key, editor, etag string, base, edited []byte, prompt *bufio.Reader) ([]byte, error) {
+	for {
+		current, err := cmd.getFileMetadata(key, bucket)
+		if err != nil {
+			return nil, err
+		}
+		if aws.StringValue(current.ETag) == etag {
+			return edited, nil
+		}
+
+		fmt.Fprintf(os.Stderr, "the file: %s has been changed in the bucket since it was retrieved, [m]erge, [o]verwrite or [a]bort? ", key)
+		answer, err := prompt.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Fprintf(os.Stderr, "\n")
+			return nil, fmt.Errorf("the file: %s was changed in the bucket during the edit, aborting", key)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "o", "overwrite":
+			return edited, nil
+		case "m", "merge":
+			theirs, theirsTag, err := cmd.getFileWithETag(bucket, key)
+			if err != nil {
+				return nil, err
+			}
+			lines, conflict := mergeLines(splitLines(string(base)), splitLines(string(edited)), splitLines(string(theirs)))
+			edited = []byte(strings.Join(lines, ""))
+			// step: any conflicts must be resolved by hand
+			if conflict {
+				fmt.Fprintf(os.Stderr, "the changes conflict, please resolve the conflicts marked in the file\n")
+				if edited, err = inlineEdit(key, edited, editor); err != nil {
+					return nil, err
+				}
+			}
+			base, etag = theirs, theirsTag
+		case "a", "abort":
+			return nil, fmt.Errorf("the edit of the file: %s has been aborted", key)
+		}
+	}
+}
+
+//
+// validateEdit validates the edited content, reopening the editor on the invalid content until it's
+// valid or the edit is aborted
 //
-func inlineEdit(content []byte, editor string) (string, error) {
-	// step: create a temporary file and write the data
-	tmp, err := ioutil.TempFile("/tmp", "edit.XXXXXXXX")
+func validateEdit(key string, check validator, editor string, edited []byte, prompt *bufio.Reader) ([]byte, error) {
+	for {
+		err := validateContent(check, key, edited)
+		if err == nil {
+			return edited, nil
+		}
+
+		fmt.Fprintf(os.Stderr, "%s\n[E]dit again or [a]bort? ", err)
+		answer, readErr := prompt.ReadString('\n')
+		if readErr != nil && answer == "" {
+			fmt.Fprintf(os.Stderr, "\n")
+			return nil, fmt.Errorf("the edit of the file: %s has been aborted, %s", key, err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "", "e", "edit":
+			if edited, err = inlineEdit(key, edited, editor); err != nil {
+				return nil, fmt.Errorf("unable to edit the file: %s, error: %s", key, err)
+			}
+		case "a", "abort":
+			return nil, fmt.Errorf("the edit of the file: %s has been aborted", key)
+		}
+	}
+}
+
+//
+// inlineEdit opens the content in the editor, returning the edited content. The file is written into a
+// private directory, preferably on a memory backed filesystem, and wiped once we are done
+//
+func inlineEdit(key string, content []byte, editor string) ([]byte, error) {
+	// step: create a private directory to hold the file, we keep the name so editors can detect the format
+	dir, err := privateTempDir()
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, filepath.Base(key))
+	defer wipeFile(path)
+
 	// step: write out the content of the file
-	if _, err := tmp.Write(content); err != nil {
-		return "", err
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		return nil, err
 	}
-	tmp.Close()
 
 	// step: open the secret with the editor
-	cmd := exec.Command(editor, tmp.Name())
+	cmd := exec.Command(editor, path)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 
 	// step: execute the editor
 	if err := cmd.Run(); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return tmp.Name(), nil
+	return ioutil.ReadFile(path)
+}
+
+//
+// privateTempDir creates a directory only accessible to us, preferring a memory backed filesystem
+//
+func privateTempDir() (string, error) {
+	dir, err := ioutil.TempDir("/dev/shm", "s3secrets.")
+	if err != nil {
+		if dir, err = ioutil.TempDir("", "s3secrets."); err != nil {
+			return "", err
+		}
+	}
+
+	return dir, os.Chmod(dir, 0700)
+}
+
+//
+// wipeFile overwrites the content of the file with zeros before removing it. Note, editors which replace
+// rather than rewrite the file will leave the previous content to the filesystem
+//
+func wipeFile(path string) {
+	if info, err := os.Stat(path); err == nil {
+		if file, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			file.Write(make([]byte, info.Size()))
+			file.Sync()
+			file.Close()
+		}
+	}
+	os.Remove(path)
 }
@@ -0,0 +1,233 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+//
+// newTestEditor creates an editor which replaces the file with the content of $S3SECRETS_TEST_EDIT
+//
+func newTestEditor(t *testing.T, dir string) string {
+	editor := filepath.Join(dir, "editor.sh")
+	script := "#!/bin/sh\nprintf '%s' \"$S3SECRETS_TEST_EDIT\" > \"$1\"\n"
+	if err := ioutil.WriteFile(editor, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	return editor
+}
+
+//
+// withStdin runs the method with stdin reading the content
+//
+func withStdin(t *testing.T, content string, method func() error) error {
+	file, err := ioutil.TempFile("", "s3secrets-stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = file
+	defer func() { os.Stdin = stdin }()
+
+	return method()
+}
+
+func TestEditFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test requires a posix shell")
+	}
+	dir, cleanup := newTestDir(t, nil)
+	defer cleanup()
+	editor := newTestEditor(t, dir)
+	defer os.Unsetenv("S3SECRETS_TEST_EDIT")
+
+	cases := []struct {
+		key      string
+		envelope bool
+		flags    []string
+		// the content written by the editor
+		edit string
+		// the answers given to any prompts
+		stdin    string
+		expected string
+		// the number of versions expected of the file
+		versions int
+		error    bool
+	}{
+		{
+			key:      "config.json",
+			edit:     `{"user": "admin"}`,
+			expected: `{"user": "admin"}`,
+			versions: 2,
+		},
+		{
+			key:      "config.json",
+			envelope: true,
+			edit:     `{"user": "admin"}`,
+			expected: `{"user": "admin"}`,
+			versions: 2,
+		},
+		{
+			key:      "config.json",
+			edit:     `{"user": "app"}`,
+			expected: `{"user": "app"}`,
+			versions: 1,
+		},
+		{
+			key:      "config.json",
+			flags:    []string{"--validate", "auto"},
+			edit:     `{"user": `,
+			stdin:    "a\n",
+			expected: `{"user": "app"}`,
+			versions: 1,
+			error:    true,
+		},
+		{
+			key:      "config.json",
+			flags:    []string{"--validate", "none"},
+			edit:     `{"user": `,
+			expected: `{"user": `,
+			versions: 2,
+		},
+		{
+			key:      "config.json",
+			flags:    []string{"--validate", "unknown"},
+			edit:     `{"user": "admin"}`,
+			expected: `{"user": "app"}`,
+			versions: 1,
+			error:    true,
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		putTestFile(t, cmd, c.key, `{"user": "app"}`, c.envelope)
+		os.Setenv("S3SECRETS_TEST_EDIT", c.edit)
+
+		args := append([]string{"--bucket", testBucket, "--editor", editor}, c.flags...)
+		args = append(args, c.key)
+		err := withStdin(t, c.stdin, func() error {
+			_, err := runTestCommand(t, cmd, newEditCommand(cmd), editFile, args...)
+			return err
+		})
+		if c.error && err == nil {
+			t.Errorf("case %d: expected an error", i)
+		}
+		if !c.error && err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+		}
+		if content := getTestFile(t, cmd, c.key); content != c.expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, content)
+		}
+		versions := 0
+		if err := cmd.walkBucketVersions(testBucket, c.key, func(_ *s3.ObjectVersion) error {
+			versions++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if versions != c.versions {
+			t.Errorf("case %d: expected %d versions, got: %d", i, c.versions, versions)
+		}
+		metadata, err := cmd.getFileMetadata(c.key, testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, envelope := objectEncryption(metadata); envelope != c.envelope {
+			t.Errorf("case %d: expected envelope: %t, got: %t", i, c.envelope, envelope)
+		}
+	}
+}
+
+func TestResolveConflicts(t *testing.T) {
+	cases := []struct {
+		// the content in the bucket when the edit completes, empty if unchanged
+		theirs   string
+		answer   string
+		expected string
+		error    bool
+	}{
+		{
+			expected: "a\nx\nc\nd\n",
+		},
+		{
+			theirs:   "a\nb\nc\ny\n",
+			answer:   "o\n",
+			expected: "a\nx\nc\nd\n",
+		},
+		{
+			theirs:   "a\nb\nc\ny\n",
+			answer:   "m\n",
+			expected: "a\nx\nc\ny\n",
+		},
+		{
+			theirs: "a\nb\nc\ny\n",
+			answer: "a\n",
+			error:  true,
+		},
+		{
+			theirs: "a\nb\nc\ny\n",
+			error:  true,
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		base := "a\nb\nc\nd\n"
+		putTestFile(t, cmd, "file.txt", base, false)
+		metadata, err := cmd.getFileMetadata("file.txt", testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.theirs != "" {
+			putTestFile(t, cmd, "file.txt", c.theirs, false)
+		}
+
+		prompt := bufio.NewReader(strings.NewReader(c.answer))
+		edited, err := resolveConflicts(cmd, testBucket, "file.txt", "true", aws.StringValue(metadata.ETag),
+			[]byte(base), []byte("a\nx\nc\nd\n"), prompt)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if string(edited) != c.expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, edited)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// envelopeAlgorithm is the cipher used to encrypt the content client side
+	envelopeAlgorithm = "AES-256-GCM"
+	// envelopeAlgorithmHeader is the metadata holding the cipher of a client side encrypted object
+	envelopeAlgorithmHeader = "s3secrets-envelope"
+	// envelopeKeyHeader is the metadata holding the kms wrapped data key
+	envelopeKeyHeader = "s3secrets-data-key"
+	// envelopeNonceHeader is the metadata holding the nonce used by the cipher
+	envelopeNonceHeader = "s3secrets-nonce"
+	// envelopeKMSHeader is the metadata holding the kms key which wrapped the data key
+	envelopeKMSHeader = "s3secrets-kms-id"
+)
+
+//
+// encryptEnvelope encrypts the content client side using a data key generated from the kms key, returning
+// the ciphertext and the metadata required to decrypt it, along with a checksum keyed by the data key. The
+// ciphertext is bound to the bucket and key, so it cannot be moved to another file
+//
+func (r *cliCommand) encryptEnvelope(bucket, key string, content []byte, kmsID string) ([]byte, map[string]*string, error) {
+	// step: generate a data key from kms
+	plaintext, wrapped, keyID, err := r.keyService.GenerateDataKey(kmsID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to generate a data key, error: %s", err)
+	}
+	defer zeroBytes(plaintext)
+
+	gcm, err := newEnvelopeCipher(plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	// step: generate a random nonce and seal the content
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	checksum, err := computeChecksum(plaintext, bytes.NewReader(content))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return gcm.Seal(nil, nonce, content, envelopeContext(bucket, key)), map[string]*string{
+		envelopeAlgorithmHeader: aws.String(envelopeAlgorithm),
+		envelopeKeyHeader:       aws.String(base64.StdEncoding.EncodeToString(wrapped)),
+		envelopeNonceHeader:     aws.String(base64.StdEncoding.EncodeToString(nonce)),
+		envelopeKMSHeader:       aws.String(keyID),
+		checksumHeader:          aws.String(checksum),
+	}, nil
+}
+
+//
+// decryptEnvelope decrypts the content of the client side encrypted object at the bucket and key
+//
+func (r *cliCommand) decryptEnvelope(bucket, key string, content []byte, metadata map[string]*string) ([]byte, error) {
+	if algorithm, _ := getMetadata(metadata, envelopeAlgorithmHeader); algorithm != envelopeAlgorithm {
+		return nil, fmt.Errorf("unsupported envelope encryption algorithm: %s", algorithm)
+	}
+	wrapped, err := decodeMetadata(metadata, envelopeKeyHeader)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := decodeMetadata(metadata, envelopeNonceHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	// step: unwrap the data key via kms
+	kmsID, _ := getMetadata(metadata, envelopeKMSHeader)
+	plaintext, _, err := r.keyService.Decrypt(kmsID, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the data key, error: %s", err)
+	}
+	defer zeroBytes(plaintext)
+
+	gcm, err := newEnvelopeCipher(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid envelope nonce size: %d", len(nonce))
+	}
+
+	decrypted, err := gcm.Open(nil, nonce, content, envelopeContext(bucket, key))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt the file: %s, error: %s", key, err)
+	}
+
+	return decrypted, nil
+}
+
+//
+// envelopeContext returns the additional data authenticated with the content, the location of the object
+//
+func envelopeContext(bucket, key string) []byte {
+	return []byte(bucket + "/" + key)
+}
+
+//
+// newEnvelopeCipher creates the aes-gcm cipher from the data key
+//
+func newEnvelopeCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+//
+// isEnvelope checks if the object metadata indicates client side encryption
+//
+func isEnvelope(metadata map[string]*string) bool {
+	_, found := getMetadata(metadata, envelopeAlgorithmHeader)
+
+	return found
+}
+
+//
+// objectEncryption returns the kms key used to protect the object and if it was encrypted client side
+//
+func objectEncryption(head *s3.HeadObjectOutput) (string, bool) {
+	if isEnvelope(head.Metadata) {
+		kmsID, _ := getMetadata(head.Metadata, envelopeKMSHeader)
+		return kmsID, true
+	}
+
+	return aws.StringValue(head.SSEKMSKeyId), false
+}
+
+//
+// retrievedEncryption returns the kms key of a retrieved object and whether it's client side encrypted
+//
+func retrievedEncryption(resp *s3.GetObjectOutput) (string, bool) {
+	return objectEncryption(&s3.HeadObjectOutput{Metadata: resp.Metadata, SSEKMSKeyId: resp.SSEKMSKeyId})
+}
+
+//
+// getMetadata retrieves a value from the object metadata, the keys are canonicalized by the
+// http headers so we have to perform a case insensitive match
+//
+func getMetadata(metadata map[string]*string, name string) (string, bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, name) {
+			return aws.StringValue(v), true
+		}
+	}
+
+	return "", false
+}
+
+//
+// decodeMetadata retrieves and base64 decodes a value from the object metadata
+//
+func decodeMetadata(metadata map[string]*string, name string) ([]byte, error) {
+	value, found := getMetadata(metadata, name)
+	if !found {
+		return nil, fmt.Errorf("the object is missing the envelope metadata: %s", name)
+	}
+
+	return base64.StdEncoding.DecodeString(value)
+}
+
+//
+// zeroBytes wipes the content of the slice
+//
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,306 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	envNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	// envReferenceRegex matches a ${VAR} reference to another variable
+	envReferenceRegex = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+)
+
+//
+// parseVariables decodes the content of a secret into a map of environment variables, the
+// format is taken from the extension of the key, else sniffed from the content
+//
+func parseVariables(key string, content []byte) (map[string]string, error) {
+	switch strings.ToLower(filepath.Ext(key)) {
+	case ".json":
+		return parseJSONVariables(content)
+	case ".yml", ".yaml":
+		return parseYAMLVariables(content)
+	case ".env":
+		return parseEnvironment(content)
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		return parseJSONVariables(content)
+	}
+
+	return parseEnvironment(content)
+}
+
+//
+// parseJSONVariables decodes a json document into environment variables
+//
+func parseJSONVariables(content []byte) (map[string]string, error) {
+	values := make(map[string]interface{}, 0)
+	if err := decodeJSON(content, &values); err != nil {
+		return nil, err
+	}
+	variables := make(map[string]string, 0)
+	for k, v := range values {
+		value, err := toVariable(v)
+		if err != nil {
+			return nil, fmt.Errorf("variable: %s, error: %s", k, err)
+		}
+		variables[k] = value
+	}
+
+	return variables, nil
+}
+
+//
+// decodeJSON decodes a json document, the numbers are decoded as json.Number so they are written back
+// verbatim rather than losing their precision as a float64
+//
+func decodeJSON(content []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid content after the json document")
+	}
+
+	return nil
+}
+
+//
+// parseYAMLVariables decodes a yaml document into environment variables
+//
+func parseYAMLVariables(content []byte) (map[string]string, error) {
+	values := make(map[string]interface{}, 0)
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return nil, err
+	}
+	variables := make(map[string]string, 0)
+	for k, v := range values {
+		value, err := toVariable(normalizeYAML(v))
+		if err != nil {
+			return nil, fmt.Errorf("variable: %s, error: %s", k, err)
+		}
+		variables[k] = value
+	}
+
+	return variables, nil
+}
+
+//
+// parseEnvironment decodes a dotenv style document, i.e. KEY=VALUE per line, supporting comments,
+// an optional export prefix and single or double quoted values
+//
+func parseEnvironment(content []byte) (map[string]string, error) {
+	return parseEnvironmentWith(content, nil)
+}
+
+//
+// parseEnvironmentWith decodes a dotenv style document, expanding any ${VAR} references in the unquoted
+// and double quoted values when a lookup is given. The references are resolved by the lookup, falling
+// back to the variables defined earlier in the document
+//
+func parseEnvironmentWith(content []byte, lookup func(string) (string, bool)) (map[string]string, error) {
+	variables := make(map[string]string, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		// step: skip any empty lines or comments
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimSpace(strings.TrimPrefix(text, "export "))
+
+		items := strings.SplitN(text, "=", 2)
+		if len(items) != 2 {
+			return nil, fmt.Errorf("line %d is invalid, expected KEY=VALUE", line)
+		}
+		name := strings.TrimSpace(items[0])
+		if !envNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("line %d has an invalid variable name: '%s'", line, name)
+		}
+		raw := strings.TrimSpace(items[1])
+		value, err := unquoteValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d is invalid, error: %s", line, err)
+		}
+		if lookup != nil && !strings.HasPrefix(raw, "'") {
+			value = expandReferences(value, variables, lookup)
+		}
+		variables[name] = value
+	}
+
+	return variables, scanner.Err()
+}
+
+//
+// expandReferences replaces the ${VAR} references in the value, any unknown variables are empty
+//
+func expandReferences(value string, variables map[string]string, lookup func(string) (string, bool)) string {
+	return envReferenceRegex.ReplaceAllStringFunc(value, func(reference string) string {
+		name := envReferenceRegex.FindStringSubmatch(reference)[1]
+		if v, found := lookup(name); found {
+			return v
+		}
+
+		return variables[name]
+	})
+}
+
+//
+// loadEnvironmentFile sets the variables from the --environment-file given in the global options, this must
+// happen before the arguments are parsed so the flags can take their defaults from the variables. The
+// precedence is the flags, then the existing environment and lastly the file
+//
+func loadEnvironmentFile(args []string, flags []cli.Flag) error {
+	// step: find the global flags which do not take a value, including the help and version flags
+	switches := map[string]bool{"h": true, "help": true, "v": true, "version": true}
+	for _, x := range flags {
+		switch x.(type) {
+		case cli.BoolFlag, cli.BoolTFlag:
+			for _, name := range strings.Split(x.GetName(), ",") {
+				switches[strings.TrimSpace(name)] = true
+			}
+		}
+	}
+
+	var filename string
+	for i := 0; i < len(args); i++ {
+		// step: the global options end at the first argument which is not a flag, i.e. the command
+		if args[i] == "--" || args[i] == "-" || !strings.HasPrefix(args[i], "-") {
+			break
+		}
+		name, value := strings.TrimLeft(args[i], "-"), ""
+		if items := strings.SplitN(name, "=", 2); len(items) == 2 {
+			name, value = items[0], items[1]
+		} else if !switches[name] && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		if name == "environment-file" {
+			filename = value
+		}
+	}
+	if filename == "" {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("unable to read the environment file: %s, error: %s", filename, err)
+	}
+	variables, err := parseEnvironmentWith(content, os.LookupEnv)
+	if err != nil {
+		return fmt.Errorf("the environment file: %s is invalid, %s", filename, err)
+	}
+	for name, value := range variables {
+		// step: the existing environment takes precedence over the file
+		if _, found := os.LookupEnv(name); found {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//
+// unquoteValue removes any quoting and trailing comments from a dotenv value
+//
+func unquoteValue(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	switch quote := value[0]; quote {
+	case '"', '\'':
+		end := strings.LastIndex(value, string(quote))
+		if end <= 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		unquoted := value[1:end]
+		if quote == '"' {
+			unquoted = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(unquoted)
+		}
+		return unquoted, nil
+	}
+	// step: strip any trailing comments from unquoted values
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+
+	return value, nil
+}
+
+//
+// toVariable converts a decoded value into the string form of an environment variable
+//
+func toVariable(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(encoded), nil
+	default:
+		return fmt.Sprintf("%v", value), nil
+	}
+}
+
+//
+// normalizeYAML converts the map[interface{}]interface{} produced by the yaml decoder into something
+// we can json encode
+//
+func normalizeYAML(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for k, x := range value {
+			converted[fmt.Sprintf("%v", k)] = normalizeYAML(x)
+		}
+		return converted
+	case []interface{}:
+		for i, x := range value {
+			value[i] = normalizeYAML(x)
+		}
+		return value
+	}
+
+	return v
+}
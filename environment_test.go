@@ -0,0 +1,151 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestParseVariables(t *testing.T) {
+	cases := []struct {
+		key       string
+		content   string
+		variables map[string]string
+		error     bool
+	}{
+		{
+			key:       "app.json",
+			content:   `{"ACCOUNT": 123456789012, "RATIO": 0.10, "DEBUG": true, "EMPTY": null, "NAME": "app"}`,
+			variables: map[string]string{"ACCOUNT": "123456789012", "RATIO": "0.10", "DEBUG": "true", "EMPTY": "", "NAME": "app"},
+		},
+		{
+			key:       "app.json",
+			content:   `{"NESTED": {"id": 123456789012, "tags": ["a", 1]}}`,
+			variables: map[string]string{"NESTED": `{"id":123456789012,"tags":["a",1]}`},
+		},
+		{
+			key:       "app",
+			content:   ` {"PORT": 8080}`,
+			variables: map[string]string{"PORT": "8080"},
+		},
+		{
+			key:       "app.yaml",
+			content:   "PORT: 8080\nNESTED:\n  a: b\n",
+			variables: map[string]string{"PORT": "8080", "NESTED": `{"a":"b"}`},
+		},
+		{
+			key:       "app.env",
+			content:   "# comment\nexport A=1\nB=\"two words\" \nC='${A}' # quoted\nD=${A} # comment\n",
+			variables: map[string]string{"A": "1", "B": "two words", "C": "${A}", "D": "${A}"},
+		},
+		{key: "app.json", content: `{"A": 1} {"B": 2}`, error: true},
+		{key: "app.json", content: `{"A": `, error: true},
+		{key: "app.env", content: "A\n", error: true},
+		{key: "app.env", content: "1A=b\n", error: true},
+		{key: "app.env", content: "A=\"b\n", error: true},
+	}
+	for i, c := range cases {
+		variables, err := parseVariables(c.key, []byte(c.content))
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(variables, c.variables) {
+			t.Errorf("case %d: expected: %v, got: %v", i, c.variables, variables)
+		}
+	}
+}
+
+func TestLoadEnvironmentFile(t *testing.T) {
+	dir, cleanup := newTestDir(t, map[string]string{
+		"app.env": "S3SECRETS_TEST_A=file\nS3SECRETS_TEST_B=${S3SECRETS_TEST_HOME}/b\nS3SECRETS_TEST_C='${S3SECRETS_TEST_HOME}'\n",
+		"bad.env": "S3SECRETS_TEST_A\n",
+	})
+	defer cleanup()
+	filename := filepath.Join(dir, "app.env")
+	names := []string{"S3SECRETS_TEST_A", "S3SECRETS_TEST_B", "S3SECRETS_TEST_C", "S3SECRETS_TEST_HOME"}
+	flags := []cli.Flag{cli.BoolFlag{Name: "verbose, V"}, cli.StringFlag{Name: "region, r"}}
+
+	cases := []struct {
+		args []string
+		// the existing environment
+		environ   map[string]string
+		variables map[string]string
+		error     bool
+	}{
+		{
+			args:      []string{"--environment-file", filename, "list"},
+			variables: map[string]string{"S3SECRETS_TEST_A": "file", "S3SECRETS_TEST_B": "/b", "S3SECRETS_TEST_C": "${S3SECRETS_TEST_HOME}"},
+		},
+		{
+			args:      []string{"-V", "--region", "eu-west-1", "--environment-file=" + filename, "list"},
+			environ:   map[string]string{"S3SECRETS_TEST_A": "environ", "S3SECRETS_TEST_HOME": "/home"},
+			variables: map[string]string{"S3SECRETS_TEST_A": "environ", "S3SECRETS_TEST_B": "/home/b", "S3SECRETS_TEST_C": "${S3SECRETS_TEST_HOME}"},
+		},
+		{
+			args:      []string{"list", "--environment-file", filename},
+			variables: map[string]string{},
+		},
+		{
+			args:      []string{"--region", "--environment-file", filename},
+			variables: map[string]string{},
+		},
+		{args: []string{"--environment-file", filepath.Join(dir, "missing.env")}, error: true},
+		{args: []string{"--environment-file", filepath.Join(dir, "bad.env")}, error: true},
+	}
+	for i, c := range cases {
+		for _, name := range names {
+			os.Unsetenv(name)
+		}
+		for name, value := range c.environ {
+			os.Setenv(name, value)
+		}
+		err := loadEnvironmentFile(c.args, flags)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		variables := make(map[string]string, 0)
+		for _, name := range names[:3] {
+			if value, found := os.LookupEnv(name); found {
+				variables[name] = value
+			}
+		}
+		if !reflect.DeepEqual(variables, c.variables) {
+			t.Errorf("case %d: expected: %v, got: %v", i, c.variables, variables)
+		}
+	}
+	for _, name := range names {
+		os.Unsetenv(name)
+	}
+}
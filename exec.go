@@ -0,0 +1,160 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/urfave/cli"
+)
+
+//
+// newExecCommand creates a new exec command
+//
+func newExecCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "exec",
+		Usage:     "retrieves one or more files as environment variables and executes a command with them",
+		ArgsUsage: "-- command [arguments...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringSliceFlag{
+				Name:  "s, secret",
+				Usage: "the key of a file (dotenv, json or yaml) in the bucket containing the variables, can be used multiple times",
+			},
+			cli.BoolFlag{
+				Name:  "no-override",
+				Usage: "do not override any variables already present in the environment",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s", "l:secret:a"}, cmd, execCommand)
+		},
+	}
+}
+
+//
+// execCommand retrieves the secrets and runs the command with them in it's environment
+//
+func execCommand(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	override := !cx.Bool("no-override")
+
+	if len(cx.Args()) <= 0 {
+		return fmt.Errorf("you have not specified a command to execute")
+	}
+
+	// step: retrieve and decode the secrets, later keys take precedence
+	variables := make(map[string]string, 0)
+	for _, key := range cx.StringSlice("secret") {
+		content, err := cmd.getFile(bucket, key)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve the file: %s, error: %s", key, err)
+		}
+		values, err := parseVariables(key, content)
+		if err != nil {
+			return fmt.Errorf("unable to parse the file: %s, error: %s", key, err)
+		}
+		for k, v := range values {
+			if !envNameRegex.MatchString(k) {
+				return fmt.Errorf("the file: %s contains an invalid variable name: '%s'", key, k)
+			}
+			variables[k] = v
+		}
+	}
+
+	// step: create the command
+	child := exec.Command(cx.Args().First(), cx.Args().Tail()...)
+	child.Env = mergeEnvironment(os.Environ(), variables, override)
+	child.Stdin = os.Stdin
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+
+	if err := child.Start(); err != nil {
+		return fmt.Errorf("unable to execute the command: %s, error: %s", cx.Args().First(), err)
+	}
+
+	// step: pass any signals we receive onto the child
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, forwardedSignals...)
+	go func() {
+		for sig := range signalCh {
+			child.Process.Signal(sig)
+		}
+	}()
+
+	// step: wait for the command to finish, stop forwarding and pass back the exit code
+	err := child.Wait()
+	signal.Stop(signalCh)
+	close(signalCh)
+	if code := exitCode(err); code != 0 {
+		os.Exit(code)
+	}
+
+	return nil
+}
+
+//
+// mergeEnvironment adds the variables to the environment
+//
+func mergeEnvironment(environ []string, variables map[string]string, override bool) []string {
+	var list []string
+	existing := make(map[string]bool, 0)
+	for _, x := range environ {
+		name := strings.SplitN(x, "=", 2)[0]
+		if _, found := variables[name]; found && override {
+			continue
+		}
+		existing[name] = true
+		list = append(list, x)
+	}
+	for k, v := range variables {
+		if existing[k] {
+			continue
+		}
+		list = append(list, k+"="+v)
+	}
+
+	return list
+}
+
+//
+// exitCode extracts the exit code from the result of a command
+//
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		if status, ok := e.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+
+	return 1
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+func TestExecCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test requires a posix shell")
+	}
+	os.Setenv("S3SECRETS_TEST_EXISTING", "original")
+	defer os.Unsetenv("S3SECRETS_TEST_EXISTING")
+
+	script := `echo "$S3SECRETS_TEST_USER:$S3SECRETS_TEST_EXISTING"`
+	cases := []struct {
+		args     []string
+		expected string
+		error    bool
+	}{
+		{
+			args:     []string{"--secret", "app.env", "--", "sh", "-c", script},
+			expected: "app:override\n",
+		},
+		{
+			args:     []string{"--secret", "app.env", "--no-override", "--", "sh", "-c", script},
+			expected: "app:original\n",
+		},
+		{
+			args:     []string{"--secret", "app.env", "--secret", "config.json", "--", "sh", "-c", script},
+			expected: "json:override\n",
+		},
+		{
+			args:     []string{"--secret", "config.yaml", "--", "sh", "-c", script},
+			expected: "yaml:original\n",
+		},
+		{
+			args:  []string{"--secret", "app.env"},
+			error: true,
+		},
+		{
+			args:  []string{"--secret", "missing.env", "--", "true"},
+			error: true,
+		},
+		{
+			args:  []string{"--secret", "invalid.json", "--", "true"},
+			error: true,
+		},
+		{
+			args:  []string{"--secret", "app.env", "--", "s3secrets-missing-command"},
+			error: true,
+		},
+	}
+	cmd := newTestCommand(t)
+	putTestFile(t, cmd, "app.env", "S3SECRETS_TEST_USER=app\nS3SECRETS_TEST_EXISTING=override\n", true)
+	putTestFile(t, cmd, "config.json", `{"S3SECRETS_TEST_USER": "json"}`, false)
+	putTestFile(t, cmd, "config.yaml", "S3SECRETS_TEST_USER: yaml\n", false)
+	putTestFile(t, cmd, "invalid.json", `{"not-a-name": "value"}`, false)
+
+	for i, c := range cases {
+		args := append([]string{"--bucket", testBucket}, c.args...)
+		output, err := captureStdout(t, func() error {
+			_, err := runTestCommand(t, cmd, newExecCommand(cmd), execCommand, args...)
+			return err
+		})
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if output != c.expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, output)
+		}
+	}
+}
+
+func TestMergeEnvironment(t *testing.T) {
+	cases := []struct {
+		environ   []string
+		variables map[string]string
+		override  bool
+		expected  []string
+	}{
+		{
+			environ:   []string{"A=1", "B=2"},
+			variables: map[string]string{"C": "3"},
+			expected:  []string{"A=1", "B=2", "C=3"},
+		},
+		{
+			environ:   []string{"A=1", "B=2"},
+			variables: map[string]string{"A": "3"},
+			override:  true,
+			expected:  []string{"A=3", "B=2"},
+		},
+		{
+			environ:   []string{"A=1", "B=2"},
+			variables: map[string]string{"A": "3"},
+			expected:  []string{"A=1", "B=2"},
+		},
+		{
+			environ:   []string{"A=x=y"},
+			variables: map[string]string{"B": "a=b"},
+			expected:  []string{"A=x=y", "B=a=b"},
+		},
+	}
+	for i, c := range cases {
+		environ := mergeEnvironment(c.environ, c.variables, c.override)
+		sort.Strings(environ)
+		if !reflect.DeepEqual(environ, c.expected) {
+			t.Errorf("case %d: expected: %v, got: %v", i, c.expected, environ)
+		}
+	}
+}
@@ -58,13 +58,13 @@ func (r *formatter) fields(v map[string]interface{}) *formatter {
 		if err != nil {
 			return r
 		}
-		fmt.Fprintf(r.writer, string(encode)+"\n")
+		fmt.Fprintf(r.writer, "%s\n", encode)
 	case "json":
 		encode, err := json.Marshal(v)
 		if err != nil {
 			return r
 		}
-		fmt.Fprintf(r.writer, string(encode)+"\n")
+		fmt.Fprintf(r.writer, "%s\n", encode)
 	default:
 	}
 
@@ -79,3 +79,11 @@ func (r *formatter) log(message string, args ...interface{}) *formatter {
 
 	return r
 }
+
+// buffered returns a formatter of the same format writing to another writer
+func (r *formatter) buffered(writer io.Writer) *formatter {
+	return &formatter{
+		format: r.format,
+		writer: writer,
+	}
+}
@@ -0,0 +1,461 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+	"golang.org/x/crypto/ed25519"
+)
+
+// charsets are the characters available to the generated passwords
+var charsets = map[string]string{
+	"alnum":   "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+	"alpha":   "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"lower":   "abcdefghijklmnopqrstuvwxyz",
+	"upper":   "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"numeric": "0123456789",
+	"hex":     "0123456789abcdef",
+	"ascii":   "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~",
+}
+
+// oidEd25519 identifies an ed25519 key, see rfc 8410
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// ed25519PrivateKeyInfo is the pkcs8 encoding of an ed25519 private key, the x509 package of go 1.6 has no
+// support for ed25519 so we encode the keys ourselves
+type ed25519PrivateKeyInfo struct {
+	Version    int
+	Algorithm  pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// ed25519PublicKeyInfo is the pkix encoding of an ed25519 public key
+type ed25519PublicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// generatedFile is a file produced by the generator, uploaded to the path plus the suffix
+type generatedFile struct {
+	suffix  string
+	content []byte
+}
+
+//
+// newGenerateCommand creates a new generate command
+//
+func newGenerateCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:  "generate",
+		Usage: "generates a random secret, key pair or certificate and uploads it into the bucket",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the aws kms id to use when performing operations",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.StringFlag{
+				Name:  "p, path",
+				Usage: "the key in the bucket to upload the secret to, key pairs add a .pub and certificates a .key and .crt",
+			},
+			cli.StringFlag{
+				Name:  "t, type",
+				Usage: "the type of secret to generate, either password, hex, base64, rsa, ecdsa, ed25519 or tls",
+				Value: "password",
+			},
+			cli.IntFlag{
+				Name:  "l, length",
+				Usage: "the number of characters in a password or the number of random bytes in a hex or base64 secret",
+				Value: 32,
+			},
+			cli.StringFlag{
+				Name:  "c, charset",
+				Usage: "the characters used in a password, either alnum, alpha, lower, upper, numeric, hex or ascii",
+				Value: "alnum",
+			},
+			cli.IntFlag{
+				Name:  "bits",
+				Usage: "the size of a rsa key (default 2048) or the curve of an ecdsa key, either 256, 384 or 521 (default 256)",
+			},
+			cli.StringFlag{
+				Name:  "key-type",
+				Usage: "the type of key used by a tls certificate, either rsa, ecdsa or ed25519",
+				Value: "ecdsa",
+			},
+			cli.StringFlag{
+				Name:  "common-name",
+				Usage: "the common name of a tls certificate, defaults to the first host",
+			},
+			cli.StringSliceFlag{
+				Name:  "host",
+				Usage: "a hostname or ip address added to the tls certificate, can be used multiple times",
+			},
+			cli.DurationFlag{
+				Name:  "validity",
+				Usage: "the duration a tls certificate is valid for",
+				Value: time.Duration(365 * 24 * time.Hour),
+			},
+			cli.BoolFlag{
+				Name:  "envelope",
+				Usage: "encrypt the files client side with a kms data key rather than using s3 server side encryption",
+			},
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: "overwrite the key/s in the bucket if they already exist",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s", "l:kms:s", "l:path:s"}, cmd, generateSecret)
+		},
+	}
+}
+
+//
+// generateSecret generates the secret in memory and uploads it into the bucket
+//
+func generateSecret(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	kms := cx.String("kms")
+	keyName := strings.TrimPrefix(cx.String("path"), "/")
+	kind := cx.String("type")
+
+	if keyName == "" || strings.HasSuffix(keyName, "/") {
+		return fmt.Errorf("invalid path: '%s', it must be the name of a key", keyName)
+	}
+
+	// step: generate the files
+	var files []generatedFile
+	var err error
+	switch kind {
+	case "password":
+		files, err = generatePassword(cx.Int("length"), cx.String("charset"))
+	case "hex", "base64":
+		files, err = generateToken(kind, cx.Int("length"))
+	case "rsa", "ecdsa", "ed25519":
+		files, err = generateKeyPair(kind, cx.Int("bits"))
+	case "tls":
+		files, err = generateCertificate(cx.String("key-type"), cx.Int("bits"), cx.String("common-name"), cx.StringSlice("host"), cx.Duration("validity"))
+	default:
+		return fmt.Errorf("unsupported secret type: %s", kind)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, x := range files {
+			zeroBytes(x.content)
+		}
+	}()
+
+	// step: refuse to overwrite any existing keys unless forced
+	if !cx.Bool("force") {
+		for _, x := range files {
+			if found, err := cmd.hasKey(keyName+x.suffix, bucket); err != nil {
+				return err
+			} else if found {
+				return fmt.Errorf("the key: %s already exists, use --force to overwrite it", keyName+x.suffix)
+			}
+		}
+	}
+
+	for _, x := range files {
+		key := keyName + x.suffix
+		if err := cmd.uploadFile(bucket, key, bytes.NewReader(x.content), kms, cx.Bool("envelope")); err != nil {
+			return fmt.Errorf("failed to upload the key: %s, error: %s", key, err)
+		}
+
+		o.fields(map[string]interface{}{
+			"action": "generate",
+			"type":   kind,
+			"bucket": bucket,
+			"key":    key,
+		}).log("successfully generated a %s secret and pushed to s3://%s/%s\n", kind, bucket, key)
+	}
+
+	return nil
+}
+
+//
+// generatePassword generates a random password of the length from the characters in the charset
+//
+func generatePassword(length int, charset string) ([]generatedFile, error) {
+	characters, found := charsets[charset]
+	if !found {
+		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	}
+	if length <= 0 {
+		return nil, fmt.Errorf("the length must be greater than zero")
+	}
+
+	password := make([]byte, length)
+	max := big.NewInt(int64(len(characters)))
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return nil, err
+		}
+		password[i] = characters[n.Int64()]
+	}
+
+	return []generatedFile{{content: password}}, nil
+}
+
+//
+// generateToken generates the number of random bytes, encoded as hex or base64
+//
+func generateToken(encoding string, length int) ([]generatedFile, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("the length must be greater than zero")
+	}
+	b, err := randomBytes(length)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(b)
+
+	token := []byte(hex.EncodeToString(b))
+	if encoding == "base64" {
+		token = []byte(base64.StdEncoding.EncodeToString(b))
+	}
+
+	return []generatedFile{{content: token}}, nil
+}
+
+//
+// generateKeyPair generates a private key, uploaded to the path, and it's public key, uploaded with a .pub suffix
+//
+func generateKeyPair(kind string, bits int) ([]generatedFile, error) {
+	key, err := generatePrivateKey(kind, bits)
+	if err != nil {
+		return nil, err
+	}
+	private, public, err := encodeKeyPair(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return []generatedFile{{content: private}, {suffix: ".pub", content: public}}, nil
+}
+
+//
+// generateCertificate generates a self signed certificate, the key is uploaded with a .key suffix and the
+// certificate with a .crt suffix
+//
+func generateCertificate(kind string, bits int, commonName string, hosts []string, validity time.Duration) ([]generatedFile, error) {
+	if commonName == "" && len(hosts) > 0 {
+		commonName = hosts[0]
+	}
+	if commonName == "" {
+		return nil, fmt.Errorf("you must specify a common name or at least one host for the certificate")
+	}
+	key, err := generatePrivateKey(kind, bits)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             now.Add(-5 * time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	// step: only rsa keys are used for key exchange
+	if _, found := key.(*rsa.PrivateKey); found {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	for _, x := range hosts {
+		if ip := net.ParseIP(x); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, x)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	private, _, err := encodeKeyPair(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return []generatedFile{
+		{suffix: ".key", content: private},
+		{suffix: ".crt", content: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})},
+	}, nil
+}
+
+//
+// generatePrivateKey generates a rsa, ecdsa or ed25519 private key
+//
+func generatePrivateKey(kind string, bits int) (crypto.Signer, error) {
+	switch kind {
+	case "rsa":
+		if bits == 0 {
+			bits = 2048
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("a rsa key must be at least 2048 bits")
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case "ecdsa":
+		curves := map[int]elliptic.Curve{0: elliptic.P256(), 256: elliptic.P256(), 384: elliptic.P384(), 521: elliptic.P521()}
+		curve, found := curves[bits]
+		if !found {
+			return nil, fmt.Errorf("unsupported ecdsa curve: %d, must be 256, 384 or 521", bits)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case "ed25519":
+		if bits != 0 {
+			return nil, fmt.Errorf("an ed25519 key has a fixed size, you cannot specify the bits")
+		}
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	return nil, fmt.Errorf("unsupported key type: %s", kind)
+}
+
+//
+// encodeKeyPair encodes the private key as a pkcs1, ec or pkcs8 (ed25519) and the public key as a pkix pem block
+//
+func encodeKeyPair(key crypto.Signer) ([]byte, []byte, error) {
+	var block *pem.Block
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return encodeEd25519KeyPair(k)
+	case *rsa.PrivateKey:
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		private, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, nil, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: private}
+	default:
+		return nil, nil, fmt.Errorf("unsupported private key type: %T", key)
+	}
+	defer zeroBytes(block.Bytes)
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(block), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), nil
+}
+
+//
+// encodeEd25519KeyPair encodes the ed25519 private key as a pkcs8 and the public key as a pkix pem block
+//
+func encodeEd25519KeyPair(key ed25519.PrivateKey) ([]byte, []byte, error) {
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: oidEd25519}
+	// step: the private key is the seed, wrapped in an octet string
+	seed, err := asn1.Marshal(key[:ed25519.SeedSize])
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zeroBytes(seed)
+	private, err := asn1.Marshal(ed25519PrivateKeyInfo{Algorithm: algorithm, PrivateKey: seed})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer zeroBytes(private)
+	public, err := asn1.Marshal(ed25519PublicKeyInfo{
+		Algorithm: algorithm,
+		PublicKey: asn1.BitString{Bytes: key[ed25519.SeedSize:], BitLength: 8 * ed25519.PublicKeySize},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}), nil
+}
+
+//
+// parseEd25519PrivateKey decodes a pkcs8 encoded ed25519 private key
+//
+func parseEd25519PrivateKey(der []byte) (ed25519.PrivateKey, error) {
+	var info ed25519PrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing data after the private key")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidEd25519) {
+		return nil, fmt.Errorf("the private key is not an ed25519 key")
+	}
+	var seed []byte
+	if _, err := asn1.Unmarshal(info.PrivateKey, &seed); err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid ed25519 private key size: %d", len(seed))
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+//
+// parseEd25519PublicKey decodes a pkix encoded ed25519 public key
+//
+func parseEd25519PublicKey(der []byte) (ed25519.PublicKey, error) {
+	var info ed25519PublicKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing data after the public key")
+	}
+	if !info.Algorithm.Algorithm.Equal(oidEd25519) {
+		return nil, fmt.Errorf("the public key is not an ed25519 key")
+	}
+	if len(info.PublicKey.Bytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid ed25519 public key size: %d", len(info.PublicKey.Bytes))
+	}
+
+	return ed25519.PublicKey(info.PublicKey.Bytes), nil
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+//
+// pemType returns the type of the first pem block in the content
+//
+func pemType(content string) string {
+	block, _ := pem.Decode([]byte(content))
+	if block == nil {
+		return ""
+	}
+
+	return block.Type
+}
+
+func TestGenerateSecret(t *testing.T) {
+	cases := []struct {
+		args []string
+		keys []string
+		// check validates the content of the generated keys
+		check func(files map[string]string) bool
+		error bool
+	}{
+		{
+			args: []string{"--path", "password"},
+			keys: []string{"password"},
+			check: func(files map[string]string) bool {
+				return regexp.MustCompile(`^[a-zA-Z0-9]{32}$`).MatchString(files["password"])
+			},
+		},
+		{
+			args: []string{"--path", "/password", "--length", "8", "--charset", "numeric", "--envelope"},
+			keys: []string{"password"},
+			check: func(files map[string]string) bool {
+				return regexp.MustCompile(`^[0-9]{8}$`).MatchString(files["password"])
+			},
+		},
+		{
+			args: []string{"--path", "token", "--type", "hex", "--length", "16"},
+			keys: []string{"token"},
+			check: func(files map[string]string) bool {
+				return regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(files["token"])
+			},
+		},
+		{
+			args: []string{"--path", "token", "--type", "base64", "--length", "16"},
+			keys: []string{"token"},
+			check: func(files map[string]string) bool {
+				decoded, err := base64.StdEncoding.DecodeString(files["token"])
+				return err == nil && len(decoded) == 16
+			},
+		},
+		{
+			args: []string{"--path", "id", "--type", "rsa"},
+			keys: []string{"id", "id.pub"},
+			check: func(files map[string]string) bool {
+				return pemType(files["id"]) == "RSA PRIVATE KEY" && pemType(files["id.pub"]) == "PUBLIC KEY"
+			},
+		},
+		{
+			args: []string{"--path", "id", "--type", "ecdsa", "--bits", "384"},
+			keys: []string{"id", "id.pub"},
+			check: func(files map[string]string) bool {
+				return pemType(files["id"]) == "EC PRIVATE KEY" && pemType(files["id.pub"]) == "PUBLIC KEY"
+			},
+		},
+		{
+			args: []string{"--path", "id", "--type", "ed25519"},
+			keys: []string{"id", "id.pub"},
+			check: func(files map[string]string) bool {
+				private, _ := pem.Decode([]byte(files["id"]))
+				public, _ := pem.Decode([]byte(files["id.pub"]))
+				if private == nil || private.Type != "PRIVATE KEY" || public == nil || public.Type != "PUBLIC KEY" {
+					return false
+				}
+				key, err := parseEd25519PrivateKey(private.Bytes)
+				if err != nil {
+					return false
+				}
+				publicKey, err := parseEd25519PublicKey(public.Bytes)
+				if err != nil {
+					return false
+				}
+				signature := ed25519.Sign(key, []byte("message"))
+
+				return ed25519.Verify(publicKey, []byte("message"), signature) && validatePEM([]byte(files["id"]+files["id.pub"])) == nil
+			},
+		},
+		{
+			args: []string{"--path", "tls", "--type", "tls", "--host", "example.com", "--host", "127.0.0.1"},
+			keys: []string{"tls.crt", "tls.key"},
+			check: func(files map[string]string) bool {
+				pair, err := tls.X509KeyPair([]byte(files["tls.crt"]), []byte(files["tls.key"]))
+				if err != nil {
+					return false
+				}
+				cert, err := x509.ParseCertificate(pair.Certificate[0])
+				return err == nil && cert.Subject.CommonName == "example.com" && cert.VerifyHostname("127.0.0.1") == nil
+			},
+		},
+		{
+			args:  []string{"--path", "existing"},
+			error: true,
+		},
+		{
+			args: []string{"--path", "existing", "--force"},
+			keys: []string{"existing"},
+		},
+		{
+			args:  []string{"--path", "dir/"},
+			error: true,
+		},
+		{
+			args:  []string{"--path", "password", "--charset", "unknown"},
+			error: true,
+		},
+		{
+			args:  []string{"--path", "password", "--length", "0"},
+			error: true,
+		},
+		{
+			args:  []string{"--path", "id", "--type", "rsa", "--bits", "1024"},
+			error: true,
+		},
+		{
+			args:  []string{"--path", "id", "--type", "ecdsa", "--bits", "128"},
+			error: true,
+		},
+		{
+			args:  []string{"--path", "id", "--type", "ed25519", "--bits", "256"},
+			error: true,
+		},
+		{
+			args:  []string{"--path", "tls", "--type", "tls"},
+			error: true,
+		},
+		{
+			args:  []string{"--path", "secret", "--type", "unknown"},
+			error: true,
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		putTestFile(t, cmd, "existing", "existing", false)
+
+		args := append([]string{"--bucket", testBucket, "--kms", testKMS}, c.args...)
+		_, err := runTestCommand(t, cmd, newGenerateCommand(cmd), generateSecret, args...)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			if keys := testKeys(t, cmd); len(keys) != 1 {
+				t.Errorf("case %d: expected nothing to be uploaded, got: %v", i, keys)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		var keys []string
+		files := make(map[string]string, 0)
+		for _, key := range testKeys(t, cmd) {
+			if content := getTestFile(t, cmd, key); key != "existing" || content != "existing" {
+				keys = append(keys, key)
+				files[key] = content
+			}
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("case %d: expected the keys: %v, got: %v", i, c.keys, keys)
+			continue
+		}
+		if c.check != nil && !c.check(files) {
+			t.Errorf("case %d: the generated content is invalid: %q", i, files)
+		}
+	}
+}
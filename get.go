@@ -17,7 +17,6 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -26,6 +25,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/urfave/cli"
 )
 
@@ -45,7 +45,22 @@ func newGetCommand(cmd *cliCommand) cli.Command {
 			cli.StringFlag{
 				Name:  "p, perms",
 				Usage: "the file permissions on any newly created files",
-				Value: "0744",
+				Value: "0644",
+			},
+			cli.StringFlag{
+				Name:  "dir-perms",
+				Usage: "the permissions on any directories created",
+				Value: "0755",
+			},
+			cli.IntFlag{
+				Name:  "uid",
+				Usage: "the user id to own the files, by default the files are owned by the current user",
+				Value: -1,
+			},
+			cli.IntFlag{
+				Name:  "gid",
+				Usage: "the group id to own the files, by default the files are owned by the current group",
+				Value: -1,
 			},
 			cli.BoolFlag{
 				Name:  "r, recursive",
@@ -55,6 +70,19 @@ func newGetCommand(cmd *cliCommand) cli.Command {
 				Name:  "flatten",
 				Usage: "do not maintain the directory structure, flattern all files into a single directory (default true)",
 			},
+			cli.StringFlag{
+				Name:  "version-id",
+				Usage: "retrieve a specific version of the file rather than the latest, cannot be used with sync or recursive",
+			},
+			cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "the number of files to retrieve in parallel",
+				Value: 1,
+			},
+			cli.BoolTFlag{
+				Name:  "fail-fast",
+				Usage: "stop retrieving at the first failure, rather than continuing and reporting all the failures (default true)",
+			},
 			cli.BoolFlag{
 				Name:  "sync",
 				Usage: "continously synchronize the file/s between the bucket and destination folder",
@@ -64,6 +92,35 @@ func newGetCommand(cmd *cliCommand) cli.Command {
 				Usage: "the time interval between successive pollings, i.e how long we should wait to recheck",
 				Value: time.Duration(30 * time.Second),
 			},
+			cli.BoolFlag{
+				Name:  "prune",
+				Usage: "remove any files we have written which have since been deleted from the bucket when syncing",
+			},
+			cli.IntFlag{
+				Name:  "prune-limit",
+				Usage: "the maximum number of files a single sync is permitted to remove, exceeding it skips the removal",
+				Value: 10,
+			},
+			cli.StringFlag{
+				Name:  "on-change-exec",
+				Usage: "a shell command to execute when one or more files have changed in a sync, the files are passed in $S3SECRETS_CHANGED_FILES",
+			},
+			cli.StringFlag{
+				Name:  "on-change-signal",
+				Usage: "a signal to send to the process in the pid file when one or more files have changed in a sync, i.e. SIGHUP",
+			},
+			cli.StringFlag{
+				Name:  "pid-file",
+				Usage: "the path to a file containing the pid of the process to signal on changes",
+			},
+			cli.StringFlag{
+				Name:  "listen",
+				Usage: "the interface to serve the /healthz, /readyz and /metrics endpoints on when syncing, i.e. 127.0.0.1:8080",
+			},
+			cli.DurationFlag{
+				Name:  "health-max-age",
+				Usage: "the maximum age of the last successful sync before /healthz fails (defaults to three sync intervals)",
+			},
 			cli.StringFlag{
 				Name:   "d, output-dir",
 				Usage:  "the path to the directory in which to save the files",
@@ -95,6 +152,16 @@ func getFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	recursive := cx.Bool("recursive")
 	syncEnabled := cx.Bool("sync")
 	syncInterval := cx.Duration("sync-interval")
+	prune := cx.Bool("prune")
+	pruneLimit := cx.Int("prune-limit")
+	versionID := cx.String("version-id")
+	concurrency := cx.Int("concurrency")
+	failFast := cx.BoolT("fail-fast")
+
+	// step: a specific version only makes sense for a single file
+	if versionID != "" && (syncEnabled || recursive || len(getPaths(cx)) != 1) {
+		return fmt.Errorf("a version id can only be used to retrieve a single file and not when syncing or recursive")
+	}
 
 	// step: validate the filter if any
 	var filter *regexp.Regexp
@@ -102,20 +169,53 @@ func getFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 		return fmt.Errorf("filter: %s is invalid, message: %s", cx.String("filter"), err)
 	}
 
+	// step: parse the permissions and ownership of the files
+	options := fileOptions{uid: cx.Int("uid"), gid: cx.Int("gid")}
+	if options.mode, err = parseFileMode(cx.String("perms")); err != nil {
+		return err
+	}
+	if options.dirMode, err = parseFileMode(cx.String("dir-perms")); err != nil {
+		return err
+	}
+
+	// step: create the hooks to run on changes
+	hook, err := newChangeHook(cx.String("on-change-exec"), cx.String("on-change-signal"), cx.String("pid-file"))
+	if err != nil {
+		return err
+	}
+
+	// step: are we exposing the health and metrics endpoints?
+	var metrics *syncMetrics
+	if address := cx.String("listen"); address != "" {
+		maxAge := cx.Duration("health-max-age")
+		if maxAge <= 0 {
+			maxAge = 3 * syncInterval
+		}
+		metrics = newSyncMetrics(maxAge)
+		if err := metrics.listen(address); err != nil {
+			return fmt.Errorf("unable to listen on: %s, error: %s", address, err)
+		}
+		cmd.store = &instrumentedStore{store: cmd.store, metrics: metrics}
+		cmd.keyService = &instrumentedKeyService{keyService: cmd.keyService, metrics: metrics}
+	}
+
 	// step: create the output directory if required
-	if err = os.MkdirAll(directory, 0755); err != nil {
+	if err = os.MkdirAll(directory, options.dirMode); err != nil {
 		return err
 	}
 
 	// step: create a signal to handle exits and a ticker for intervals
-	signalCh := make(chan os.Signal)
+	signalCh := make(chan os.Signal, 1)
 	signal.Notify(signalCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	tickerCh := time.NewTicker(1)
 	exitCh := make(chan error, 1)
 	firstTime := true
+	synced := false
 
 	// step: create a map for etags - used to maintainer the etags of the files
 	fileTags := make(map[string]string, 0)
+	// step: create a map of the keys to the files we have written
+	filePaths := make(map[string]string, 0)
 
 	for {
 		select {
@@ -127,71 +227,145 @@ func getFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 				tickerCh = time.NewTicker(syncInterval)
 				firstTime = false
 			}
-			// step: iterate the paths specified on the command line
+			// step: iterate the paths specified on the command line, keeping a list of the files changed
+			var changed []string
+			seen := make(map[string]bool, 0)
+			// step: the files the keys in the listing resolve to, which must never be pruned
+			resolved := make(map[string]bool, 0)
 			err := func() error {
+				// step: iterate the paths, queuing the files which are new or have changed
+				var tasks []func(*formatter) error
+				var files []*s3.Object
+				var filenames []string
 				for _, bucketPath := range getPaths(cx) {
 					path := strings.TrimPrefix(bucketPath, "/")
-					// step: retrieve a list of files under this path
-					list, err := cmd.listBucketKeys(bucket, path)
-					if err != nil {
-						o.fields(map[string]interface{}{
-							"bucket": bucket,
-							"path":   path,
-							"error":  err.Error(),
-						}).log("unable to retrieve a listing in bucket: %s, path: %s\n", bucket, path)
-
-						return err
-					}
-
 					// step: iterate the files under the path
-					for _, file := range list {
+					err := cmd.walkBucketKeys(bucket, path, "", func(file *s3.Object) error {
 						keyName := strings.TrimPrefix(*file.Key, "/")
 						// step: apply the filter and ignore everything were not interested in
 						if !filter.MatchString(keyName) {
-							continue
+							return nil
 						}
 						// step: are we recursive? i.e. if not, check the file ends with the filename
 						if !recursive && !strings.HasSuffix(path, keyName) {
-							continue
+							return nil
 						}
-
-						// step: if we have download this file before, check the etag has changed
-						if etag, found := fileTags[keyName]; found && etag == *file.ETag {
-							continue // we can skip the file, nothing has changed
+						// step: skip any files already queued under another path
+						if seen[keyName] {
+							return nil
 						}
+						seen[keyName] = true
 
 						// step: are we flattening the files
 						filename := fmt.Sprintf("%s/%s", directory, keyName)
 						if flatten {
 							filename = fmt.Sprintf("%s/%s", directory, filepath.Base(keyName))
 						}
+						resolved[filename] = true
+
+						// step: if we have download this file before, check the etag has changed
+						if etag, found := fileTags[keyName]; found && etag == *file.ETag {
+							return nil // we can skip the file, nothing has changed
+						}
 
-						// step: retrieve file and write the content to disk
-						if err := processFile(filename, keyName, bucket, cmd); err != nil {
+						files = append(files, file)
+						filenames = append(filenames, filename)
+
+						tasks = append(tasks, func(o *formatter) error {
+							// step: retrieve file and write the content to disk
+							if err := processFile(filename, keyName, versionID, bucket, cmd, options); err != nil {
+								o.fields(map[string]interface{}{
+									"action":      "get",
+									"bucket":      bucket,
+									"destination": path,
+									"error":       err.Error(),
+								}).log("failed to retrieve file: %s, error: %s\n", keyName, err)
+
+								return fmt.Errorf("failed to retrieve file: %s, error: %s", keyName, err)
+							}
+
+							// step: add the log
 							o.fields(map[string]interface{}{
 								"action":      "get",
 								"bucket":      bucket,
-								"destination": path,
-								"error":       err.Error(),
-							}).log("failed to retrieve file: %s, error: %s\n", keyName, err)
+								"destination": filename,
+								"etag":        file.ETag,
+							}).log("retrieved the file: %s and wrote to: %s\n", keyName, filename)
 
-							return err
-						}
-						// step: update the filetags
-						fileTags[keyName] = *file.ETag
+							return nil
+						})
 
-						// step: add the log
+						return nil
+					})
+					if err != nil {
 						o.fields(map[string]interface{}{
-							"action":      "get",
-							"bucket":      bucket,
-							"destination": filename,
-							"etag":        file.ETag,
-						}).log("retrieved the file: %s and wrote to: %s\n", keyName, filename)
+							"bucket": bucket,
+							"path":   path,
+							"error":  err.Error(),
+						}).log("unable to retrieve a listing in bucket: %s, path: %s\n", bucket, path)
+
+						return err
+					}
+				}
+
+				// step: retrieve the files over the worker pool and update the filetags of those retrieved
+				errs := runTasks(o, concurrency, failFast, tasks)
+				for i, file := range files {
+					if errs[i] != nil {
+						continue
+					}
+					keyName := strings.TrimPrefix(*file.Key, "/")
+					fileTags[keyName] = *file.ETag
+					filePaths[keyName] = filenames[i]
+					changed = append(changed, filenames[i])
+					if metrics != nil {
+						metrics.recordBytes(*file.Size)
 					}
 				}
 
-				return nil
+				return aggregateErrors(errs)
 			}()
+			// step: remove any files whose keys have been deleted, only if we have a complete listing
+			if prune && err == nil {
+				removed, pruneErr := pruneFiles(fileTags, filePaths, seen, resolved, pruneLimit)
+				for _, filename := range removed {
+					o.fields(map[string]interface{}{
+						"action":      "prune",
+						"bucket":      bucket,
+						"destination": filename,
+					}).log("removed the file: %s as it no longer exists in the bucket\n", filename)
+				}
+				if pruneErr != nil {
+					o.fields(map[string]interface{}{
+						"action": "prune",
+						"bucket": bucket,
+						"error":  pruneErr.Error(),
+					}).log("failed to prune the files, error: %s\n", pruneErr)
+				}
+				changed = append(changed, removed...)
+			}
+
+			if metrics != nil {
+				metrics.recordSync(err, len(changed))
+			}
+
+			// step: run the hooks once for the batch, ignoring the initial sync
+			if hook != nil && synced && len(changed) > 0 {
+				if err := hook.trigger(changed); err != nil {
+					o.fields(map[string]interface{}{
+						"action": "hook",
+						"files":  changed,
+						"error":  err.Error(),
+					}).log("failed to run the change hooks, error: %s\n", err)
+				} else {
+					o.fields(map[string]interface{}{
+						"action": "hook",
+						"files":  changed,
+					}).log("successfully ran the change hooks for %d changed files\n", len(changed))
+				}
+			}
+			synced = true
+
 			// step: if we are not in a sync loop we can exit
 			if !syncEnabled {
 				exitCh <- err
@@ -204,18 +378,50 @@ func getFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 }
 
 //
-// processFile is responsible for retrieving the files
+// pruneFiles removes the files for any keys we have written which were not seen in the last listing, a file
+// which a key in the listing also resolves to, i.e. when flattening, is kept
 //
-func processFile(path, key, bucket string, cmd *cliCommand) error {
-	// step: retrieve the file content
-	content, err := cmd.getFile(bucket, key)
-	if err != nil {
-		return err
+func pruneFiles(fileTags, filePaths map[string]string, seen, resolved map[string]bool, limit int) ([]string, error) {
+	var keys []string
+	for key := range fileTags {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
 	}
-	// step: ensure the directory structure
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return err
+	// step: check we are not about to remove more than permitted
+	if len(keys) > limit {
+		return nil, fmt.Errorf("refusing to remove %d files, exceeds the limit of %d per sync", len(keys), limit)
 	}
-	// step: create the file for writing
-	return ioutil.WriteFile(path, content, 0644)
+
+	var removed []string
+	for _, key := range keys {
+		filename := filePaths[key]
+		delete(fileTags, key)
+		delete(filePaths, key)
+		// step: the file may hold the content of the removed key, so have the next sync retrieve it again
+		if resolved[filename] {
+			for k, x := range filePaths {
+				if x == filename {
+					delete(fileTags, k)
+				}
+			}
+			continue
+		}
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		removed = append(removed, filename)
+	}
+
+	return removed, nil
+}
+
+//
+// processFile is responsible for retrieving the files
+//
+func processFile(path, key, versionID, bucket string, cmd *cliCommand, options fileOptions) error {
+	// step: retrieve the file content, atomically writing the file
+	return writeFileWith(path, options, func(file *os.File) error {
+		return cmd.downloadFile(bucket, key, versionID, file)
+	})
 }
@@ -0,0 +1,234 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+//
+// readTestDir returns the content of the files under the directory, keyed by their relative path
+//
+func readTestDir(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(name)] = string(content)
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestGetFiles(t *testing.T) {
+	cases := []struct {
+		args  []string
+		files map[string]string
+		error bool
+	}{
+		{
+			args:  []string{"app/config.json"},
+			files: map[string]string{"config.json": "v2"},
+		},
+		{
+			args:  []string{"--recursive", "app/"},
+			files: map[string]string{"config.json": "v2", "app.env": "envelope"},
+		},
+		{
+			args:  []string{"--recursive", "--flatten=false", "app/"},
+			files: map[string]string{"app/config.json": "v2", "app/app.env": "envelope"},
+		},
+		{
+			args:  []string{"--recursive", "--filter", `\.env$`},
+			files: map[string]string{"app.env": "envelope", "other.env": "other"},
+		},
+		{
+			args:  []string{"--version-id", "first", "app/config.json"},
+			files: map[string]string{"config.json": "v1"},
+		},
+		{
+			args:  []string{"--recursive", "--version-id", "first", "app/"},
+			error: true,
+		},
+		{
+			args:  []string{"--filter", "[", "app/config.json"},
+			error: true,
+		},
+		{
+			args:  []string{"--perms", "abc", "app/config.json"},
+			error: true,
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		putTestFile(t, cmd, "app/config.json", "v1", false)
+		putTestFile(t, cmd, "app/config.json", "v2", false)
+		putTestFile(t, cmd, "app/app.env", "envelope", true)
+		putTestFile(t, cmd, "other.env", "other", false)
+
+		// step: the first version of the config is referred to as first
+		var first string
+		err := cmd.walkBucketVersions(testBucket, "app/config.json", func(x *s3.ObjectVersion) error {
+			if !aws.BoolValue(x.IsLatest) {
+				first = aws.StringValue(x.VersionId)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, x := range c.args {
+			if x == "first" {
+				c.args[j] = first
+			}
+		}
+
+		dir, cleanup := newTestDir(t, nil)
+		args := append([]string{"--bucket", testBucket, "--output-dir", dir}, c.args...)
+		_, err = runTestCommand(t, cmd, newGetCommand(cmd), getFiles, args...)
+		files := readTestDir(t, dir)
+		cleanup()
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(files, c.files) {
+			t.Errorf("case %d: expected the files: %v, got: %v", i, c.files, files)
+		}
+	}
+}
+
+func TestPruneFiles(t *testing.T) {
+	cases := []struct {
+		// the keys written and the files they were written to
+		written map[string]string
+		// the keys in the latest listing and the files they resolve to
+		seen     []string
+		resolved []string
+		limit    int
+		removed  []string
+		retained []string
+		error    bool
+	}{
+		{
+			written: map[string]string{"a": "a", "b": "b"},
+			seen:    []string{"a", "b"},
+			limit:   10,
+		},
+		{
+			written:  map[string]string{"a": "a", "b": "b"},
+			seen:     []string{"a"},
+			resolved: []string{"a"},
+			limit:    10,
+			removed:  []string{"b"},
+			retained: []string{"a"},
+		},
+		{
+			written:  map[string]string{"x/a": "a", "y/a": "a"},
+			seen:     []string{"y/a"},
+			resolved: []string{"a"},
+			limit:    10,
+			retained: []string{"a"},
+		},
+		{
+			written: map[string]string{"a": "a", "b": "b"},
+			limit:   1,
+			error:   true,
+		},
+	}
+	for i, c := range cases {
+		dir, cleanup := newTestDir(t, nil)
+		fileTags := make(map[string]string, 0)
+		filePaths := make(map[string]string, 0)
+		for key, name := range c.written {
+			filename := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(filename, []byte(key), 0644); err != nil {
+				t.Fatal(err)
+			}
+			fileTags[key] = "etag"
+			filePaths[key] = filename
+		}
+		seen := make(map[string]bool, 0)
+		for _, key := range c.seen {
+			seen[key] = true
+		}
+		resolved := make(map[string]bool, 0)
+		for _, name := range c.resolved {
+			resolved[filepath.Join(dir, name)] = true
+		}
+
+		removed, err := pruneFiles(fileTags, filePaths, seen, resolved, c.limit)
+		files := readTestDir(t, dir)
+		cleanup()
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		var names []string
+		for _, x := range removed {
+			names = append(names, filepath.Base(x))
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, c.removed) {
+			t.Errorf("case %d: expected to remove: %v, got: %v", i, c.removed, names)
+		}
+		for _, name := range c.retained {
+			if _, found := files[name]; !found {
+				t.Errorf("case %d: expected the file: %s to be retained", i, name)
+			}
+		}
+		// step: a retained file whose key was removed must be retrieved again by the next sync
+		for key := range c.written {
+			if _, found := fileTags[key]; found && !seen[key] {
+				t.Errorf("case %d: expected the etag of the key: %s to be removed", i, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// changeHook is run once per sync when one or more files have changed
+type changeHook struct {
+	// the command to execute via the shell
+	command string
+	// the signal to send to the process
+	signal syscall.Signal
+	// the file containing the pid of the process to signal
+	pidFile string
+}
+
+//
+// newChangeHook creates a change hook from the options, returns nil if no hooks are configured
+//
+func newChangeHook(command, signal, pidFile string) (*changeHook, error) {
+	if command == "" && signal == "" {
+		return nil, nil
+	}
+	hook := &changeHook{command: command, pidFile: pidFile}
+	if signal != "" {
+		if !signalSupported {
+			return nil, fmt.Errorf("signaling a process on changes is not supported on this platform")
+		}
+		sig, err := parseSignal(signal)
+		if err != nil {
+			return nil, err
+		}
+		if pidFile == "" {
+			return nil, fmt.Errorf("you must specify a pid file when signaling a process on changes")
+		}
+		hook.signal = sig
+	}
+
+	return hook, nil
+}
+
+//
+// trigger runs the hooks for the files which have changed
+//
+func (r *changeHook) trigger(files []string) error {
+	if r.signal != 0 {
+		pid, err := readPidFile(r.pidFile)
+		if err != nil {
+			return err
+		}
+		if err := signalProcess(pid, r.signal); err != nil {
+			return fmt.Errorf("unable to send signal to pid: %d, error: %s", pid, err)
+		}
+	}
+	if r.command != "" {
+		cmd := exec.Command("/bin/sh", "-c", r.command)
+		cmd.Env = append(os.Environ(), "S3SECRETS_CHANGED_FILES="+strings.Join(files, " "))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("the command: '%s' failed, error: %s", r.command, err)
+		}
+	}
+
+	return nil
+}
+
+//
+// parseSignal converts the name or number of a signal, i.e. SIGHUP, HUP or 1
+//
+func parseSignal(name string) (syscall.Signal, error) {
+	if number, err := strconv.Atoi(name); err == nil && number > 0 {
+		return syscall.Signal(number), nil
+	}
+	if sig, found := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]; found {
+		return sig, nil
+	}
+
+	return 0, fmt.Errorf("unsupported signal: %s", name)
+}
+
+//
+// readPidFile reads the process id from the file
+//
+func readPidFile(path string) (int, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("unable to read the pid file: %s, error: %s", path, err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("the pid file: %s does not contain a valid pid", path)
+	}
+
+	return pid, nil
+}
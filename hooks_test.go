@@ -0,0 +1,118 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestNewChangeHook(t *testing.T) {
+	cases := []struct {
+		command, signal, pidFile string
+		none                     bool
+		error                    bool
+	}{
+		{none: true},
+		{command: "true"},
+		{signal: "HUP", pidFile: "app.pid"},
+		{signal: "SIGUSR1", pidFile: "app.pid"},
+		{signal: "1", pidFile: "app.pid"},
+		{signal: "HUP", error: true},
+		{signal: "NOPE", pidFile: "app.pid", error: true},
+		{signal: "-1", pidFile: "app.pid", error: true},
+	}
+	for i, c := range cases {
+		if !signalSupported && c.signal != "" {
+			c.error = true
+		}
+		hook, err := newChangeHook(c.command, c.signal, c.pidFile)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if (hook == nil) != c.none {
+			t.Errorf("case %d: expected no hook: %t, got: %v", i, c.none, hook)
+		}
+	}
+}
+
+func TestReadPidFile(t *testing.T) {
+	dir, cleanup := newTestDir(t, map[string]string{"good.pid": "1234\n", "bad.pid": "abc", "zero.pid": "0"})
+	defer cleanup()
+
+	cases := map[string]int{"good.pid": 1234, "bad.pid": 0, "zero.pid": 0, "missing.pid": 0}
+	for name, expected := range cases {
+		pid, err := readPidFile(filepath.Join(dir, name))
+		if expected == 0 && err == nil {
+			t.Errorf("file: %s, expected an error", name)
+		}
+		if expected != 0 && (err != nil || pid != expected) {
+			t.Errorf("file: %s, expected the pid: %d, got: %d, error: %v", name, expected, pid, err)
+		}
+	}
+}
+
+func TestChangeHookTrigger(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test requires a posix shell and signals")
+	}
+	dir, cleanup := newTestDir(t, map[string]string{"app.pid": fmt.Sprintf("%d", os.Getpid())})
+	defer cleanup()
+	output := filepath.Join(dir, "changed")
+
+	hook, err := newChangeHook(`printf '%s' "$S3SECRETS_CHANGED_FILES" > `+output, "USR1", filepath.Join(dir, "app.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, hook.signal)
+	defer signal.Stop(signals)
+
+	if err := hook.trigger([]string{"a.txt", "dir/b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-signals:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the process to be signaled")
+	}
+	content, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a.txt dir/b.txt" {
+		t.Errorf("expected the changed files: %q, got: %q", "a.txt dir/b.txt", content)
+	}
+
+	// step: a failing command is an error
+	hook = &changeHook{command: "exit 3"}
+	if err := hook.trigger(nil); err == nil {
+		t.Errorf("expected the failing command to be an error")
+	}
+}
@@ -0,0 +1,37 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// signalSupported is false as windows has no means of signaling another process
+const signalSupported = false
+
+// signalNames is empty as no signals can be sent
+var signalNames = map[string]syscall.Signal{}
+
+//
+// signalProcess is unsupported on windows
+//
+func signalProcess(pid int, sig syscall.Signal) error {
+	return fmt.Errorf("signaling a process is not supported on windows")
+}
@@ -62,10 +62,5 @@ func listKeys(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 // kmsKeys retrieves the kms keys from aws
 //
 func (r *cliCommand) kmsKeys() ([]*kms.AliasListEntry, error) {
-	resp, err := r.kmsClient.ListAliases(&kms.ListAliasesInput{})
-	if err != nil {
-		return []*kms.AliasListEntry{}, err
-	}
-
-	return resp.Aliases, nil
+	return r.keyService.ListAliases()
 }
@@ -0,0 +1,61 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListKeys(t *testing.T) {
+	cases := []struct {
+		// the keys used to encrypt a file
+		keys     []string
+		expected []string
+	}{
+		{},
+		{
+			keys:     []string{testKMS},
+			expected: []string{"test alias/test"},
+		},
+		{
+			keys:     []string{"alias/b", "a", "alias/b"},
+			expected: []string{"a alias/a", "b alias/b"},
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		for _, x := range c.keys {
+			if _, _, _, err := cmd.keyService.GenerateDataKey(x); err != nil {
+				t.Fatal(err)
+			}
+		}
+		output, err := runTestCommand(t, cmd, newListKMSCommand(cmd), listKeys)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			if line != "" {
+				lines = append(lines, strings.Join(strings.Fields(line), " "))
+			}
+		}
+		if strings.Join(lines, "\n") != strings.Join(c.expected, "\n") {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, lines)
+		}
+	}
+}
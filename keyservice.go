@@ -0,0 +1,148 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// KeyService is the key management service used to protect the data keys
+type KeyService interface {
+	// ListAliases retrieves the keys available
+	ListAliases() ([]*kms.AliasListEntry, error)
+	// GenerateDataKey returns a plaintext data key and the same key encrypted by the key, along with the key id
+	GenerateDataKey(kmsID string) ([]byte, []byte, string, error)
+	// ReEncrypt decrypts the ciphertext encrypted by the source key, if known, and encrypts it again with the
+	// key, returning the new ciphertext and the id of the key now protecting it
+	ReEncrypt(sourceKeyID, kmsID string, ciphertext []byte) ([]byte, string, error)
+	// Decrypt decrypts the ciphertext, returning the plaintext and the id of the key used, the kms id is
+	// the key expected to have encrypted it, if known
+	Decrypt(kmsID string, ciphertext []byte) ([]byte, string, error)
+}
+
+// awsKeyService is the kms implementation of the key service, the requests for a key arn are made to the
+// region of the key
+type awsKeyService struct {
+	sync.Mutex
+	// the config the clients are created from
+	config client.ConfigProvider
+	// the kms client for the default region
+	client *kms.KMS
+	// the kms clients for each region, created as required
+	regions map[string]*kms.KMS
+}
+
+//
+// newAWSKeyService creates a kms backed key service
+//
+func newAWSKeyService(config client.ConfigProvider) KeyService {
+	return &awsKeyService{
+		config:  config,
+		client:  kms.New(config),
+		regions: make(map[string]*kms.KMS, 0),
+	}
+}
+
+func (r *awsKeyService) ListAliases() ([]*kms.AliasListEntry, error) {
+	var list []*kms.AliasListEntry
+
+	err := r.client.ListAliasesPages(&kms.ListAliasesInput{}, func(page *kms.ListAliasesOutput, lastPage bool) bool {
+		list = append(list, page.Aliases...)
+		return true
+	})
+
+	return list, err
+}
+
+func (r *awsKeyService) GenerateDataKey(kmsID string) ([]byte, []byte, string, error) {
+	resp, err := r.keyClient(kmsID).GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(kmsID),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return resp.Plaintext, resp.CiphertextBlob, aws.StringValue(resp.KeyId), nil
+}
+
+func (r *awsKeyService) ReEncrypt(sourceKeyID, kmsID string, ciphertext []byte) ([]byte, string, error) {
+	// step: kms can only re-encrypt within a region, across regions the plaintext must pass through us
+	if source := r.keyClient(sourceKeyID); source != r.keyClient(kmsID) {
+		plaintext, _, err := r.Decrypt(sourceKeyID, ciphertext)
+		if err != nil {
+			return nil, "", err
+		}
+		defer zeroBytes(plaintext)
+
+		resp, err := r.keyClient(kmsID).Encrypt(&kms.EncryptInput{
+			KeyId:     aws.String(kmsID),
+			Plaintext: plaintext,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		return resp.CiphertextBlob, aws.StringValue(resp.KeyId), nil
+	}
+	resp, err := r.keyClient(kmsID).ReEncrypt(&kms.ReEncryptInput{
+		CiphertextBlob:   ciphertext,
+		DestinationKeyId: aws.String(kmsID),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return resp.CiphertextBlob, aws.StringValue(resp.KeyId), nil
+}
+
+func (r *awsKeyService) Decrypt(kmsID string, ciphertext []byte) ([]byte, string, error) {
+	resp, err := r.keyClient(kmsID).Decrypt(&kms.DecryptInput{
+		CiphertextBlob: ciphertext,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return resp.Plaintext, aws.StringValue(resp.KeyId), nil
+}
+
+//
+// keyClient returns the client for the region of the key, a key arn i.e. arn:aws:kms:REGION:ACCOUNT:key/ID
+// is in the region given, anything else is in the default region
+//
+func (r *awsKeyService) keyClient(kmsID string) *kms.KMS {
+	items := strings.Split(kmsID, ":")
+	if len(items) < 6 || items[0] != "arn" || items[3] == "" || items[3] == aws.StringValue(r.client.Config.Region) {
+		return r.client
+	}
+	region := items[3]
+
+	r.Lock()
+	defer r.Unlock()
+	client, found := r.regions[region]
+	if !found {
+		client = kms.New(r.config, &aws.Config{Region: aws.String(region)})
+		r.regions[region] = client
+	}
+
+	return client
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+const (
+	// softCiphertextVersion is the version prefix of the ciphertexts produced by the soft key service
+	softCiphertextVersion = 1
+	// localKeySuffix is the extension of the master key files in the keyring directory
+	localKeySuffix = ".key"
+)
+
+// keyring holds the master keys for the soft key service
+type keyring interface {
+	// masterKey returns the master key, optionally creating it if it does not exist
+	masterKey(kmsID string, create bool) ([]byte, error)
+	// keyIDs returns the ids of all the master keys
+	keyIDs() ([]string, error)
+}
+
+// softKeyService is a key service using locally held master keys, used offline and for testing.
+// Note, the keys are created on first use rather than having to be provisioned.
+type softKeyService struct {
+	keyring keyring
+}
+
+//
+// newMemoryKeyService creates a key service holding the keys in memory
+//
+func newMemoryKeyService() KeyService {
+	return &softKeyService{keyring: &memoryKeyring{keys: make(map[string][]byte, 0)}}
+}
+
+//
+// newLocalKeyService creates a key service holding the keys as files in the directory
+//
+func newLocalKeyService(directory string) (KeyService, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+
+	return &softKeyService{keyring: &directoryKeyring{directory: directory}}, nil
+}
+
+func (r *softKeyService) ListAliases() ([]*kms.AliasListEntry, error) {
+	ids, err := r.keyring.keyIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*kms.AliasListEntry
+	for _, id := range ids {
+		list = append(list, &kms.AliasListEntry{
+			AliasName:   aws.String("alias/" + id),
+			TargetKeyId: aws.String(id),
+		})
+	}
+
+	return list, nil
+}
+
+func (r *softKeyService) GenerateDataKey(kmsID string) ([]byte, []byte, string, error) {
+	plaintext, err := randomBytes(32)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	ciphertext, err := r.encrypt(kmsID, plaintext)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return plaintext, ciphertext, normalizeKeyID(kmsID), nil
+}
+
+func (r *softKeyService) ReEncrypt(_, kmsID string, ciphertext []byte) ([]byte, string, error) {
+	plaintext, _, err := r.Decrypt("", ciphertext)
+	if err != nil {
+		return nil, "", err
+	}
+	defer zeroBytes(plaintext)
+	if ciphertext, err = r.encrypt(kmsID, plaintext); err != nil {
+		return nil, "", err
+	}
+
+	return ciphertext, normalizeKeyID(kmsID), nil
+}
+
+//
+// encrypt encrypts the plaintext with the master key, creating the key if required
+//
+func (r *softKeyService) encrypt(kmsID string, plaintext []byte) ([]byte, error) {
+	kmsID = normalizeKeyID(kmsID)
+	if kmsID == "" || len(kmsID) > 255 {
+		return nil, fmt.Errorf("invalid key id: '%s'", kmsID)
+	}
+	key, err := r.keyring.masterKey(kmsID, true)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newEnvelopeCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	// step: the ciphertext is version | length of key id | key id | nonce | sealed content
+	ciphertext := append([]byte{softCiphertextVersion, byte(len(kmsID))}, kmsID...)
+	ciphertext = append(ciphertext, nonce...)
+
+	return gcm.Seal(ciphertext, nonce, plaintext, []byte(kmsID)), nil
+}
+
+func (r *softKeyService) Decrypt(_ string, ciphertext []byte) ([]byte, string, error) {
+	if len(ciphertext) < 2 || ciphertext[0] != softCiphertextVersion {
+		return nil, "", fmt.Errorf("invalid ciphertext")
+	}
+	size := int(ciphertext[1])
+	if len(ciphertext) < 2+size {
+		return nil, "", fmt.Errorf("invalid ciphertext")
+	}
+	kmsID := string(ciphertext[2 : 2+size])
+	ciphertext = ciphertext[2+size:]
+
+	key, err := r.keyring.masterKey(kmsID, false)
+	if err != nil {
+		return nil, "", err
+	}
+	gcm, err := newEnvelopeCipher(key)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, "", fmt.Errorf("invalid ciphertext")
+	}
+	plaintext, err := gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], []byte(kmsID))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return plaintext, kmsID, nil
+}
+
+// memoryKeyring holds the master keys in memory
+type memoryKeyring struct {
+	sync.Mutex
+	keys map[string][]byte
+}
+
+func (r *memoryKeyring) masterKey(kmsID string, create bool) ([]byte, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if key, found := r.keys[kmsID]; found {
+		return key, nil
+	}
+	if !create {
+		return nil, fmt.Errorf("the key: %s does not exist", kmsID)
+	}
+	key, err := randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	r.keys[kmsID] = key
+
+	return key, nil
+}
+
+func (r *memoryKeyring) keyIDs() ([]string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var list []string
+	for id := range r.keys {
+		list = append(list, id)
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+// directoryKeyring holds the master keys as hex encoded files within a directory
+type directoryKeyring struct {
+	sync.Mutex
+	directory string
+}
+
+func (r *directoryKeyring) masterKey(kmsID string, create bool) ([]byte, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if strings.ContainsAny(kmsID, `/\`) || strings.HasPrefix(kmsID, ".") {
+		return nil, fmt.Errorf("invalid key id: '%s'", kmsID)
+	}
+	path := filepath.Join(r.directory, kmsID+localKeySuffix)
+
+	encoded, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		return hex.DecodeString(strings.TrimSpace(string(encoded)))
+	case os.IsNotExist(err) && create:
+		key, err := randomBytes(32)
+		if err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	case os.IsNotExist(err):
+		return nil, fmt.Errorf("the key: %s does not exist", kmsID)
+	}
+
+	return nil, err
+}
+
+func (r *directoryKeyring) keyIDs() ([]string, error) {
+	files, err := ioutil.ReadDir(r.directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []string
+	for _, x := range files {
+		if x.Mode().IsRegular() && strings.HasSuffix(x.Name(), localKeySuffix) {
+			list = append(list, strings.TrimSuffix(x.Name(), localKeySuffix))
+		}
+	}
+
+	return list, nil
+}
+
+//
+// normalizeKeyID removes any alias prefix from the key id
+//
+func normalizeKeyID(kmsID string) string {
+	return strings.TrimPrefix(kmsID, "alias/")
+}
+
+//
+// randomBytes generates a slice of random bytes
+//
+func randomBytes(size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// credentialScope extracts the region from the credential scope of a signed request
+var credentialScope = regexp.MustCompile(`Credential=[^/]+/[^/]+/([^/]+)/`)
+
+//
+// newTestKMS creates a kms key service against a fake service, recording the actions requested as the
+// action@region the request was signed for
+//
+func newTestKMS(t *testing.T) (*awsKeyService, func() []string, func()) {
+	var requests []string
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "TrentService.")
+		region := ""
+		if matched := credentialScope.FindStringSubmatch(r.Header.Get("Authorization")); matched != nil {
+			region = matched[1]
+		}
+		lock.Lock()
+		requests = append(requests, action+"@"+region)
+		lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch action {
+		case "Decrypt":
+			fmt.Fprint(w, `{"KeyId": "source", "Plaintext": "cGxhaW50ZXh0"}`)
+		default:
+			fmt.Fprintf(w, `{"KeyId": "%s", "CiphertextBlob": "Y2lwaGVydGV4dA==", "Plaintext": "cGxhaW50ZXh0"}`, region)
+		}
+	}))
+	service := newAWSKeyService(session.New(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("key", "secret", ""),
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("eu-west-1"),
+		MaxRetries:  aws.Int(0),
+	})).(*awsKeyService)
+
+	return service, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		list := requests
+		requests = nil
+		return list
+	}, server.Close
+}
+
+func TestKeyClientRegion(t *testing.T) {
+	service, requests, closer := newTestKMS(t)
+	defer closer()
+
+	cases := []struct {
+		kmsID    string
+		expected []string
+	}{
+		{kmsID: "alias/test", expected: []string{"GenerateDataKey@eu-west-1"}},
+		{kmsID: "62c6abc6-d1d7-4203-ac3e-5733580dd4eb", expected: []string{"GenerateDataKey@eu-west-1"}},
+		{kmsID: "arn:aws:kms:eu-west-1:123456789012:key/a", expected: []string{"GenerateDataKey@eu-west-1"}},
+		{kmsID: "arn:aws:kms:us-east-1:123456789012:key/a", expected: []string{"GenerateDataKey@us-east-1"}},
+		{kmsID: "arn:aws:kms:us-east-1:123456789012:alias/a", expected: []string{"GenerateDataKey@us-east-1"}},
+	}
+	for i, c := range cases {
+		if _, _, _, err := service.GenerateDataKey(c.kmsID); err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if list := requests(); !reflect.DeepEqual(list, c.expected) {
+			t.Errorf("case %d: expected the requests: %v, got: %v", i, c.expected, list)
+		}
+	}
+	if service.keyClient("arn:aws:kms:us-east-1:1:key/a") != service.keyClient("arn:aws:kms:us-east-1:1:key/b") {
+		t.Errorf("expected the client of a region to be reused")
+	}
+}
+
+func TestReEncryptRegion(t *testing.T) {
+	service, requests, closer := newTestKMS(t)
+	defer closer()
+
+	cases := []struct {
+		source, destination string
+		expected            []string
+	}{
+		{
+			source:      "alias/old",
+			destination: "alias/new",
+			expected:    []string{"ReEncrypt@eu-west-1"},
+		},
+		{
+			source:      "arn:aws:kms:us-east-1:123456789012:key/a",
+			destination: "arn:aws:kms:us-east-1:123456789012:key/b",
+			expected:    []string{"ReEncrypt@us-east-1"},
+		},
+		{
+			source:      "alias/old",
+			destination: "arn:aws:kms:us-east-1:123456789012:key/b",
+			expected:    []string{"Decrypt@eu-west-1", "Encrypt@us-east-1"},
+		},
+		{
+			source:      "arn:aws:kms:us-west-2:123456789012:key/a",
+			destination: "alias/new",
+			expected:    []string{"Decrypt@us-west-2", "Encrypt@eu-west-1"},
+		},
+	}
+	for i, c := range cases {
+		_, keyID, err := service.ReEncrypt(c.source, c.destination, []byte("ciphertext"))
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		list := requests()
+		if !reflect.DeepEqual(list, c.expected) {
+			t.Errorf("case %d: expected the requests: %v, got: %v", i, c.expected, list)
+			continue
+		}
+		// step: the key id is that of the destination region
+		if region := list[len(list)-1]; !strings.HasSuffix(region, "@"+keyID) {
+			t.Errorf("case %d: expected the key id of the destination, got: %s", i, keyID)
+		}
+	}
+}
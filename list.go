@@ -19,6 +19,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/urfave/cli"
 )
 
@@ -44,6 +46,10 @@ func newListCommand(cmd *cliCommand) cli.Command {
 				Name:  "r, recursive",
 				Usage: "enable recursive option and transverse all subdirectories",
 			},
+			cli.BoolFlag{
+				Name:  "versions",
+				Usage: "list all the versions of the files, requires versioning to be enabled on the bucket",
+			},
 		},
 		Action: func(cx *cli.Context) error {
 			return handleCommand(cx, []string{"l:bucket:s"}, cmd, listFiles)
@@ -60,20 +66,20 @@ func listFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	detailed := cx.Bool("long")
 	recursive := cx.Bool("recursive")
 
+	if cx.Bool("versions") {
+		return listVersions(o, cx, cmd)
+	}
+
+	// step: if not recursive, let s3 filter out any keys which have a / in them post the prefix
+	delimiter := ""
+	if !recursive {
+		delimiter = "/"
+	}
+
 	// step: get the paths to iterate
 	for _, p := range getPaths(cx) {
-		// step: get a list of paths down that path
-		files, err := cmd.listBucketKeys(bucket, p)
-		if err != nil {
-			return err
-		}
-
-		// step: iterate the files
-		for _, k := range files {
-			// step: are we recursive? i.e. extract post prefix and ignore any keys which have a / in them
-			if strings.Contains(strings.TrimPrefix(*k.Key, p), "/") && !recursive {
-				continue
-			}
+		// step: iterate the files down that path
+		err := cmd.walkBucketKeys(bucket, p, delimiter, func(k *s3.Object) error {
 			// step: are we performing a detailed listing?
 			switch detailed {
 			case true:
@@ -90,6 +96,47 @@ func listFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 					"key": *k.Key,
 				}).log("%s\n", *k.Key)
 			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//
+// listVersions lists the versions of the files in the bucket
+//
+func listVersions(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	recursive := cx.Bool("recursive")
+
+	for _, p := range getPaths(cx) {
+		err := cmd.walkBucketVersions(bucket, p, func(k *s3.ObjectVersion) error {
+			// step: if not recursive, filter out any keys which have a / in them post the prefix
+			if !recursive && strings.Contains(strings.TrimPrefix(*k.Key, p), "/") {
+				return nil
+			}
+			latest := ""
+			if aws.BoolValue(k.IsLatest) {
+				latest = "(latest)"
+			}
+			o.fields(map[string]interface{}{
+				"key":           *k.Key,
+				"version-id":    *k.VersionId,
+				"latest":        aws.BoolValue(k.IsLatest),
+				"size":          *k.Size,
+				"etag":          *k.ETag,
+				"last-modified": k.LastModified,
+			}).log("%-34s %-10d %-20s %s %s\n", *k.VersionId, *k.Size, (*k.LastModified).Format(time.RFC822), *k.Key, latest)
+
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 	}
 
@@ -0,0 +1,87 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListFiles(t *testing.T) {
+	cases := []struct {
+		args     []string
+		expected []string
+		// the number of lines expected when the output is not deterministic
+		lines int
+	}{
+		{
+			args:     []string{},
+			expected: []string{"a.txt", "dir/b.txt", "dir/sub/c.txt"},
+		},
+		{
+			args:     []string{"--recursive=false"},
+			expected: []string{"a.txt"},
+		},
+		{
+			args:     []string{"--recursive=false", "dir/"},
+			expected: []string{"dir/b.txt"},
+		},
+		{
+			args:     []string{"dir/", "a.txt"},
+			expected: []string{"dir/b.txt", "dir/sub/c.txt", "a.txt"},
+		},
+		{
+			args:     []string{"--recursive", "dir/sub/"},
+			expected: []string{"dir/sub/c.txt"},
+		},
+		{
+			args:  []string{"--long", "--recursive"},
+			lines: 3,
+		},
+		{
+			args:  []string{"--versions", "--recursive"},
+			lines: 4,
+		},
+		{
+			args:  []string{"--versions", "--recursive=false"},
+			lines: 2,
+		},
+	}
+	cmd := newTestCommand(t)
+	putTestFile(t, cmd, "a.txt", "a", false)
+	putTestFile(t, cmd, "a.txt", "a2", false)
+	putTestFile(t, cmd, "dir/b.txt", "b", true)
+	putTestFile(t, cmd, "dir/sub/c.txt", "c", false)
+
+	for i, c := range cases {
+		args := append([]string{"--bucket", testBucket}, c.args...)
+		output, err := runTestCommand(t, cmd, newListCommand(cmd), listFiles, args...)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+		if c.expected == nil {
+			if len(lines) != c.lines {
+				t.Errorf("case %d: expected %d lines, got: %q", i, c.lines, output)
+			}
+			continue
+		}
+		if expected := strings.Join(c.expected, "\n") + "\n"; output != expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, expected, output)
+		}
+	}
+}
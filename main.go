@@ -21,5 +21,12 @@ import (
 
 func main() {
 	app := newCliApplication()
-	app.Run(os.Args)
+	// step: the environment file must be loaded before the flags are parsed
+	if err := loadEnvironmentFile(os.Args[1:], app.Flags); err != nil {
+		printError("%s", err)
+	}
+	// step: the errors have been printed by the application
+	if err := app.Run(os.Args); err != nil {
+		os.Exit(1)
+	}
 }
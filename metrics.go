@@ -0,0 +1,313 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// latencyBuckets are the upper bounds in seconds of the request latency histograms
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// syncMetrics holds the state and statistics of the sync service
+type syncMetrics struct {
+	sync.RWMutex
+	// the maximum age of the last successful sync before we are unhealthy
+	maxAge time.Duration
+	// the number of syncs performed
+	syncs int64
+	// the number of syncs which failed
+	syncErrors int64
+	// the number of bytes retrieved from the bucket
+	bytesFetched int64
+	// the number of files which have changed
+	filesChanged int64
+	// the time of the last successful sync
+	lastSuccess time.Time
+	// the request latencies, keyed by service and operation
+	latency map[string]*histogram
+}
+
+// histogram is a cumulative histogram of observations
+type histogram struct {
+	service   string
+	operation string
+	counts    []int64
+	sum       float64
+	count     int64
+}
+
+//
+// newSyncMetrics creates the metrics for the sync service
+//
+func newSyncMetrics(maxAge time.Duration) *syncMetrics {
+	return &syncMetrics{
+		maxAge:  maxAge,
+		latency: make(map[string]*histogram, 0),
+	}
+}
+
+//
+// recordSync records the result of a sync
+//
+func (r *syncMetrics) recordSync(err error, changed int) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.syncs++
+	r.filesChanged += int64(changed)
+	if err != nil {
+		r.syncErrors++
+		return
+	}
+	r.lastSuccess = time.Now()
+}
+
+//
+// recordBytes records the number of bytes retrieved from the bucket
+//
+func (r *syncMetrics) recordBytes(size int64) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.bytesFetched += size
+}
+
+//
+// observe records the latency of a request to a service
+//
+func (r *syncMetrics) observe(service, operation string, latency time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	name := service + ":" + operation
+	h, found := r.latency[name]
+	if !found {
+		h = &histogram{service: service, operation: operation, counts: make([]int64, len(latencyBuckets))}
+		r.latency[name] = h
+	}
+	seconds := latency.Seconds()
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+//
+// measure records the time since the start
+//
+func (r *syncMetrics) measure(service, operation string, start time.Time) {
+	r.observe(service, operation, time.Since(start))
+}
+
+//
+// listen starts the http service for the health and metrics endpoints
+//
+func (r *syncMetrics) listen(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", r.healthHandler)
+	mux.HandleFunc("/readyz", r.readyHandler)
+	mux.HandleFunc("/metrics", r.metricsHandler)
+
+	go http.Serve(listener, mux)
+
+	return nil
+}
+
+// healthHandler is healthy if the last successful sync is within the maximum age
+func (r *syncMetrics) healthHandler(w http.ResponseWriter, req *http.Request) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.lastSuccess.IsZero() {
+		http.Error(w, "no successful sync has been performed", http.StatusServiceUnavailable)
+		return
+	}
+	age := time.Since(r.lastSuccess)
+	if age > r.maxAge {
+		http.Error(w, fmt.Sprintf("the last successful sync was %s ago", age), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "ok, the last successful sync was %s ago\n", age)
+}
+
+// readyHandler is ready once the first sync has completed successfully
+func (r *syncMetrics) readyHandler(w http.ResponseWriter, req *http.Request) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if r.lastSuccess.IsZero() {
+		http.Error(w, "the initial sync has not completed", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "ok\n")
+}
+
+// metricsHandler writes the metrics in the prometheus text format
+func (r *syncMetrics) metricsHandler(w http.ResponseWriter, req *http.Request) {
+	r.RLock()
+	defer r.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "s3secrets_syncs_total", "counter", "the number of syncs performed", r.syncs)
+	writeMetric(w, "s3secrets_sync_errors_total", "counter", "the number of syncs which have failed", r.syncErrors)
+	writeMetric(w, "s3secrets_fetched_bytes_total", "counter", "the number of bytes retrieved from the bucket", r.bytesFetched)
+	writeMetric(w, "s3secrets_changed_files_total", "counter", "the number of files which have changed", r.filesChanged)
+	var last float64
+	if !r.lastSuccess.IsZero() {
+		last = float64(r.lastSuccess.UnixNano()) / 1e9
+	}
+	writeMetric(w, "s3secrets_last_success_timestamp_seconds", "gauge", "the time of the last successful sync", last)
+
+	// step: write the latency histograms in a consistent order
+	name := "s3secrets_request_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s the latency of the requests to s3 and kms\n# TYPE %s histogram\n", name, name)
+	var keys []string
+	for k := range r.latency {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h := r.latency[k]
+		labels := fmt.Sprintf("service=%q,operation=%q", h.service, h.operation)
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, le, h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+	}
+}
+
+//
+// writeMetric writes a single metric in the prometheus text format
+//
+func writeMetric(w io.Writer, name, kind, help string, value interface{}) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
+
+// instrumentedStore records the latency of the requests to the secret store
+type instrumentedStore struct {
+	store   SecretStore
+	metrics *syncMetrics
+}
+
+// instrumentedKeyService records the latency of the requests to the key service
+type instrumentedKeyService struct {
+	keyService KeyService
+	metrics    *syncMetrics
+}
+
+func (r *instrumentedStore) ListBuckets() ([]*s3.Bucket, error) {
+	defer r.metrics.measure("s3", "ListBuckets", time.Now())
+	return r.store.ListBuckets()
+}
+
+func (r *instrumentedStore) CreateBucket(bucket string) error {
+	defer r.metrics.measure("s3", "CreateBucket", time.Now())
+	return r.store.CreateBucket(bucket)
+}
+
+func (r *instrumentedStore) DeleteBucket(bucket string) error {
+	defer r.metrics.measure("s3", "DeleteBucket", time.Now())
+	return r.store.DeleteBucket(bucket)
+}
+
+func (r *instrumentedStore) List(bucket, prefix, delimiter string, method func(*s3.Object) error) error {
+	// step: we exclude the time spent in the method from the latency
+	var spent time.Duration
+	start := time.Now()
+	err := r.store.List(bucket, prefix, delimiter, func(x *s3.Object) error {
+		called := time.Now()
+		defer func() { spent += time.Since(called) }()
+		return method(x)
+	})
+	r.metrics.observe("s3", "List", time.Since(start)-spent)
+
+	return err
+}
+
+func (r *instrumentedStore) ListVersions(bucket, prefix string, method func(*s3.ObjectVersion) error) error {
+	defer r.metrics.measure("s3", "ListVersions", time.Now())
+	return r.store.ListVersions(bucket, prefix, method)
+}
+
+func (r *instrumentedStore) Head(bucket, key, versionID string) (*s3.HeadObjectOutput, error) {
+	defer r.metrics.measure("s3", "Head", time.Now())
+	return r.store.Head(bucket, key, versionID)
+}
+
+func (r *instrumentedStore) Get(bucket, key, versionID string) (*s3.GetObjectOutput, error) {
+	defer r.metrics.measure("s3", "Get", time.Now())
+	return r.store.Get(bucket, key, versionID)
+}
+
+func (r *instrumentedStore) Download(bucket, key, versionID, etag string, w io.WriterAt) (int64, error) {
+	defer r.metrics.measure("s3", "Download", time.Now())
+	return r.store.Download(bucket, key, versionID, etag, w)
+}
+
+func (r *instrumentedStore) Put(input *s3manager.UploadInput) error {
+	defer r.metrics.measure("s3", "Put", time.Now())
+	return r.store.Put(input)
+}
+
+func (r *instrumentedStore) Copy(input *s3.CopyObjectInput) error {
+	defer r.metrics.measure("s3", "Copy", time.Now())
+	return r.store.Copy(input)
+}
+
+func (r *instrumentedStore) Delete(bucket, key string) error {
+	defer r.metrics.measure("s3", "Delete", time.Now())
+	return r.store.Delete(bucket, key)
+}
+
+func (r *instrumentedKeyService) ListAliases() ([]*kms.AliasListEntry, error) {
+	defer r.metrics.measure("kms", "ListAliases", time.Now())
+	return r.keyService.ListAliases()
+}
+
+func (r *instrumentedKeyService) GenerateDataKey(kmsID string) ([]byte, []byte, string, error) {
+	defer r.metrics.measure("kms", "GenerateDataKey", time.Now())
+	return r.keyService.GenerateDataKey(kmsID)
+}
+
+func (r *instrumentedKeyService) ReEncrypt(sourceKeyID, kmsID string, ciphertext []byte) ([]byte, string, error) {
+	defer r.metrics.measure("kms", "ReEncrypt", time.Now())
+	return r.keyService.ReEncrypt(sourceKeyID, kmsID, ciphertext)
+}
+
+func (r *instrumentedKeyService) Decrypt(kmsID string, ciphertext []byte) ([]byte, string, error) {
+	defer r.metrics.measure("kms", "Decrypt", time.Now())
+	return r.keyService.Decrypt(kmsID, ciphertext)
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//
+// getTestHandler returns the status code and body of the handler
+//
+func getTestHandler(handler http.HandlerFunc) (int, string) {
+	recorder := httptest.NewRecorder()
+	handler(recorder, &http.Request{})
+
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestSyncMetricsHealth(t *testing.T) {
+	metrics := newSyncMetrics(time.Minute)
+	for i, handler := range []http.HandlerFunc{metrics.healthHandler, metrics.readyHandler} {
+		if code, _ := getTestHandler(handler); code != http.StatusServiceUnavailable {
+			t.Errorf("handler %d: expected to be unavailable before the first sync, got: %d", i, code)
+		}
+	}
+
+	// step: a failed sync does not make us healthy
+	metrics.recordSync(fmt.Errorf("failed"), 0)
+	if code, _ := getTestHandler(metrics.readyHandler); code != http.StatusServiceUnavailable {
+		t.Errorf("expected not to be ready after a failed sync, got: %d", code)
+	}
+	metrics.recordSync(nil, 2)
+	for i, handler := range []http.HandlerFunc{metrics.healthHandler, metrics.readyHandler} {
+		if code, _ := getTestHandler(handler); code != http.StatusOK {
+			t.Errorf("handler %d: expected to be ok after a successful sync, got: %d", i, code)
+		}
+	}
+
+	// step: the last successful sync is too old
+	metrics.lastSuccess = time.Now().Add(-2 * time.Minute)
+	if code, _ := getTestHandler(metrics.healthHandler); code != http.StatusServiceUnavailable {
+		t.Errorf("expected to be unhealthy when the last sync is too old, got: %d", code)
+	}
+	if code, _ := getTestHandler(metrics.readyHandler); code != http.StatusOK {
+		t.Errorf("expected to remain ready, got: %d", code)
+	}
+}
+
+func TestSyncMetricsOutput(t *testing.T) {
+	metrics := newSyncMetrics(time.Minute)
+	metrics.recordSync(fmt.Errorf("failed"), 0)
+	metrics.recordSync(nil, 3)
+	metrics.recordBytes(100)
+	metrics.recordBytes(28)
+	metrics.observe("s3", "Get", 20*time.Millisecond)
+	metrics.observe("s3", "Get", 2*time.Second)
+	metrics.observe("kms", "Decrypt", time.Millisecond)
+
+	code, output := getTestHandler(metrics.metricsHandler)
+	if code != http.StatusOK {
+		t.Fatalf("expected the metrics, got: %d", code)
+	}
+	for _, expected := range []string{
+		"s3secrets_syncs_total 2\n",
+		"s3secrets_sync_errors_total 1\n",
+		"s3secrets_fetched_bytes_total 128\n",
+		"s3secrets_changed_files_total 3\n",
+		`s3secrets_request_duration_seconds_bucket{service="s3",operation="Get",le="0.01"} 0` + "\n",
+		`s3secrets_request_duration_seconds_bucket{service="s3",operation="Get",le="0.025"} 1` + "\n",
+		`s3secrets_request_duration_seconds_bucket{service="s3",operation="Get",le="2.5"} 2` + "\n",
+		`s3secrets_request_duration_seconds_bucket{service="s3",operation="Get",le="+Inf"} 2` + "\n",
+		`s3secrets_request_duration_seconds_count{service="s3",operation="Get"} 2` + "\n",
+		`s3secrets_request_duration_seconds_sum{service="s3",operation="Get"} 2.02` + "\n",
+		`s3secrets_request_duration_seconds_bucket{service="kms",operation="Decrypt",le="0.005"} 1` + "\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected the metrics to contain: %q", expected)
+		}
+	}
+	// step: the histograms are written in a consistent order
+	if strings.Index(output, `service="kms"`) > strings.Index(output, `service="s3"`) {
+		t.Errorf("expected the histograms to be sorted")
+	}
+}
+
+func TestInstrumentedStore(t *testing.T) {
+	metrics := newSyncMetrics(time.Minute)
+	cmd := newTestCommand(t)
+	cmd.store = &instrumentedStore{store: cmd.store, metrics: metrics}
+	cmd.keyService = &instrumentedKeyService{keyService: cmd.keyService, metrics: metrics}
+
+	putTestFile(t, cmd, "a.txt", "hello", true)
+	if content := getTestFile(t, cmd, "a.txt"); content != "hello" {
+		t.Errorf("expected the content: hello, got: %q", content)
+	}
+	for _, name := range []string{"s3:Put", "s3:Get", "kms:GenerateDataKey", "kms:Decrypt"} {
+		if h, found := metrics.latency[name]; !found || h.count == 0 {
+			t.Errorf("expected the latency of: %s to be recorded", name)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/go-ini/ini"
+)
+
+// sharedProfiles are the profiles from the aws shared credentials and config files
+type sharedProfiles struct {
+	// the credentials file, sections are named by the profile
+	credentials *ini.File
+	// the config file, sections are named profile NAME, except the default
+	config *ini.File
+}
+
+//
+// loadSharedProfiles reads the aws shared credentials and config files, either may be missing
+//
+func loadSharedProfiles(credentialsFile, configFile string) (*sharedProfiles, error) {
+	creds, err := ini.LooseLoad(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the credentials file: %s, error: %s", credentialsFile, err)
+	}
+	config, err := ini.LooseLoad(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the config file: %s, error: %s", configFile, err)
+	}
+
+	return &sharedProfiles{credentials: creds, config: config}, nil
+}
+
+//
+// sharedProfileName returns the name of the profile, the default profile is used when none is given
+//
+func sharedProfileName(profile string) string {
+	if profile == "" {
+		return "default"
+	}
+
+	return profile
+}
+
+//
+// sections returns the sections defining the profile, the credentials file takes precedence
+//
+func (r *sharedProfiles) sections(profile string) []*ini.Section {
+	var list []*ini.Section
+	if x, err := r.credentials.GetSection(profile); err == nil {
+		list = append(list, x)
+	}
+	names := []string{"profile " + profile}
+	if profile == "default" {
+		names = []string{"default", "profile default"}
+	}
+	for _, name := range names {
+		if x, err := r.config.GetSection(name); err == nil {
+			list = append(list, x)
+		}
+	}
+
+	return list
+}
+
+//
+// exists checks if the profile is defined in either file
+//
+func (r *sharedProfiles) exists(profile string) bool {
+	return len(r.sections(profile)) > 0
+}
+
+//
+// value returns a setting of the profile
+//
+func (r *sharedProfiles) value(profile, name string) string {
+	for _, x := range r.sections(profile) {
+		if x.HasKey(name) {
+			return x.Key(name).String()
+		}
+	}
+
+	return ""
+}
+
+//
+// region returns the region of the profile
+//
+func (r *sharedProfiles) region(profile string) string {
+	return r.value(profile, "region")
+}
+
+//
+// resolve returns the credentials for the profile, a profile with a role_arn assumes the role using the
+// credentials of the source_profile, credential_source or web_identity_token_file, a profile with a
+// credential_process runs the command, else the static keys of the profile are used
+//
+func (r *sharedProfiles) resolve(profile string, config *aws.Config, cacheDir string, visited map[string]bool) (*credentials.Credentials, error) {
+	if !r.exists(profile) {
+		return nil, fmt.Errorf("the profile: %s does not exist", profile)
+	}
+	if visited[profile] {
+		return nil, fmt.Errorf("the profile: %s has a circular source_profile", profile)
+	}
+	visited[profile] = true
+	// step: the endpoint option is for s3 only, so is removed from the config for the credentials
+	config = config.Copy()
+	config.Endpoint = nil
+
+	roleARN := r.value(profile, "role_arn")
+	if roleARN == "" {
+		if command := r.value(profile, "credential_process"); command != "" {
+			return credentials.NewCredentials(&processProvider{command: command}), nil
+		}
+		return r.static(profile)
+	}
+
+	// step: retrieve the credentials used to assume the role
+	var source *credentials.Credentials
+	var sourceID string
+	tokenFile := r.value(profile, "web_identity_token_file")
+	switch sourceProfile, credentialSource := r.value(profile, "source_profile"), r.value(profile, "credential_source"); {
+	case sourceProfile == profile:
+		creds, err := r.static(profile)
+		if err != nil {
+			return nil, err
+		}
+		source, sourceID = creds, "profile:"+profile
+	case sourceProfile != "":
+		creds, err := r.resolve(sourceProfile, config, cacheDir, visited)
+		if err != nil {
+			return nil, err
+		}
+		source, sourceID = creds, "profile:"+sourceProfile
+	case credentialSource == "Environment":
+		source, sourceID = credentials.NewEnvCredentials(), os.Getenv("AWS_ACCESS_KEY_ID")
+	case credentialSource == "Ec2InstanceMetadata":
+		source, sourceID = ec2rolecreds.NewCredentials(session.New(config)), "credential_source:"+credentialSource
+	case credentialSource != "":
+		return nil, fmt.Errorf("the profile: %s has an unsupported credential_source: %s", profile, credentialSource)
+	case tokenFile == "":
+		return nil, fmt.Errorf("the profile: %s must have a source_profile, credential_source or web_identity_token_file", profile)
+	}
+
+	duration := time.Hour
+	if value := r.value(profile, "duration_seconds"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("the profile: %s has an invalid duration_seconds: %s", profile, value)
+		}
+		duration = time.Duration(seconds) * time.Second
+	}
+	options := &roleOptions{
+		roleARN:     roleARN,
+		sourceID:    sourceID,
+		sessionName: r.value(profile, "role_session_name"),
+		externalID:  r.value(profile, "external_id"),
+		mfaSerial:   r.value(profile, "mfa_serial"),
+		tokenFile:   tokenFile,
+		duration:    duration,
+		cacheDir:    cacheDir,
+	}
+	sourceConfig := config.Copy()
+	sourceConfig.Credentials = source
+	creds, err := options.assumeRole(sourceConfig)
+	if err != nil {
+		return nil, fmt.Errorf("the profile: %s is invalid, %s", profile, err)
+	}
+
+	return creds, nil
+}
+
+//
+// static returns the access keys of the profile
+//
+func (r *sharedProfiles) static(profile string) (*credentials.Credentials, error) {
+	accessKey := r.value(profile, "aws_access_key_id")
+	secretKey := r.value(profile, "aws_secret_access_key")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("the profile: %s does not have any credentials", profile)
+	}
+
+	return credentials.NewStaticCredentials(accessKey, secretKey, r.value(profile, "aws_session_token")), nil
+}
+
+// processProvider is a credentials provider which runs a command to retrieve the credentials
+type processProvider struct {
+	// the command to execute via the shell
+	command string
+	// the time the present credentials expire, zero if they don't
+	expiration time.Time
+}
+
+// processOutput is the output of the credential process
+type processOutput struct {
+	Version         int        `json:"Version"`
+	AccessKeyID     string     `json:"AccessKeyId"`
+	SecretAccessKey string     `json:"SecretAccessKey"`
+	SessionToken    string     `json:"SessionToken"`
+	Expiration      *time.Time `json:"Expiration"`
+}
+
+//
+// Retrieve runs the command, decoding the credentials from it's output
+//
+func (r *processProvider) Retrieve() (credentials.Value, error) {
+	stdout := new(bytes.Buffer)
+	cmd := exec.Command("/bin/sh", "-c", r.command)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return credentials.Value{}, fmt.Errorf("the credential process: '%s' failed, error: %s", r.command, err)
+	}
+
+	output := &processOutput{}
+	if err := json.Unmarshal(stdout.Bytes(), output); err != nil {
+		return credentials.Value{}, fmt.Errorf("the credential process: '%s' returned invalid json, error: %s", r.command, err)
+	}
+	if output.Version != 1 {
+		return credentials.Value{}, fmt.Errorf("the credential process: '%s' returned an unsupported version: %d", r.command, output.Version)
+	}
+	if output.AccessKeyID == "" || output.SecretAccessKey == "" {
+		return credentials.Value{}, fmt.Errorf("the credential process: '%s' did not return any credentials", r.command)
+	}
+	r.expiration = time.Time{}
+	if output.Expiration != nil {
+		r.expiration = *output.Expiration
+	}
+
+	return credentials.Value{
+		AccessKeyID:     output.AccessKeyID,
+		SecretAccessKey: output.SecretAccessKey,
+		SessionToken:    output.SessionToken,
+	}, nil
+}
+
+//
+// IsExpired checks if the credentials have expired or are about to
+//
+func (r *processProvider) IsExpired() bool {
+	return !r.expiration.IsZero() && time.Now().Add(roleExpiryWindow).After(r.expiration)
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// testCredentialsFile is the shared credentials file the profiles are tested against
+const testCredentialsFile = `
+[default]
+aws_access_key_id = default-key
+aws_secret_access_key = default-secret
+
+[static]
+aws_access_key_id = static-key
+aws_secret_access_key = static-secret
+aws_session_token = static-token
+
+[partial]
+aws_access_key_id = partial-key
+`
+
+// testConfigFile is the shared config file the profiles are tested against
+const testConfigFile = `
+[default]
+region = eu-west-1
+
+[profile static]
+region = eu-west-2
+aws_access_key_id = ignored-key
+
+[profile process]
+credential_process = printf '{"Version": 1, "AccessKeyId": "process-key", "SecretAccessKey": "process-secret", "SessionToken": "process-token"}'
+
+[profile process-version]
+credential_process = printf '{"Version": 2, "AccessKeyId": "process-key", "SecretAccessKey": "process-secret"}'
+
+[profile process-failed]
+credential_process = exit 1
+
+[profile loop-a]
+role_arn = arn:aws:iam::1:role/a
+source_profile = loop-b
+
+[profile loop-b]
+role_arn = arn:aws:iam::1:role/b
+source_profile = loop-a
+
+[profile unsupported]
+role_arn = arn:aws:iam::1:role/a
+credential_source = EcsContainer
+
+[profile no-source]
+role_arn = arn:aws:iam::1:role/a
+
+[profile bad-duration]
+role_arn = arn:aws:iam::1:role/a
+source_profile = static
+duration_seconds = hour
+`
+
+//
+// newTestProfiles loads the test credentials and config files
+//
+func newTestProfiles(t *testing.T) (*sharedProfiles, func()) {
+	dir, cleanup := newTestDir(t, map[string]string{"credentials": testCredentialsFile, "config": testConfigFile})
+	profiles, err := loadSharedProfiles(filepath.Join(dir, "credentials"), filepath.Join(dir, "config"))
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return profiles, cleanup
+}
+
+func TestSharedProfiles(t *testing.T) {
+	profiles, cleanup := newTestProfiles(t)
+	defer cleanup()
+
+	cases := []struct {
+		profile string
+		name    string
+		value   string
+		exists  bool
+	}{
+		{profile: "default", name: "region", value: "eu-west-1", exists: true},
+		{profile: "static", name: "region", value: "eu-west-2", exists: true},
+		{profile: "static", name: "aws_access_key_id", value: "static-key", exists: true},
+		{profile: "process", name: "aws_access_key_id", exists: true},
+		{profile: "missing", name: "region"},
+	}
+	for i, c := range cases {
+		if exists := profiles.exists(c.profile); exists != c.exists {
+			t.Errorf("case %d: expected exists: %t, got: %t", i, c.exists, exists)
+		}
+		if value := profiles.value(c.profile, c.name); value != c.value {
+			t.Errorf("case %d: expected the %s: %q, got: %q", i, c.name, c.value, value)
+		}
+	}
+	if name := sharedProfileName(""); name != "default" {
+		t.Errorf("expected the default profile, got: %s", name)
+	}
+
+	// step: either file may be missing
+	if _, err := loadSharedProfiles("missing-credentials", "missing-config"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestResolveProfile(t *testing.T) {
+	profiles, cleanup := newTestProfiles(t)
+	defer cleanup()
+
+	cases := []struct {
+		profile string
+		key     string
+		token   string
+		error   bool
+	}{
+		{profile: "default", key: "default-key"},
+		{profile: "static", key: "static-key", token: "static-token"},
+		{profile: "process", key: "process-key", token: "process-token"},
+		{profile: "process-version", error: true},
+		{profile: "process-failed", error: true},
+		{profile: "partial", error: true},
+		{profile: "missing", error: true},
+		{profile: "loop-a", error: true},
+		{profile: "unsupported", error: true},
+		{profile: "no-source", error: true},
+		{profile: "bad-duration", error: true},
+	}
+	for i, c := range cases {
+		if runtime.GOOS == "windows" && c.key == "process-key" {
+			continue
+		}
+		var value credentials.Value
+		creds, err := profiles.resolve(c.profile, &aws.Config{Region: aws.String("eu-west-1")}, "", make(map[string]bool, 0))
+		if err == nil {
+			value, err = creds.Get()
+		}
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if value.AccessKeyID != c.key || value.SessionToken != c.token {
+			t.Errorf("case %d: expected the access key: %s, token: %q, got: %s, %q", i, c.key, c.token, value.AccessKeyID, value.SessionToken)
+		}
+	}
+}
+
+func TestProcessProviderExpiry(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the credential process is run via /bin/sh")
+	}
+	expiration := time.Now().Add(roleExpiryWindow / 2).UTC().Format(time.RFC3339)
+	provider := &processProvider{
+		command: `printf '{"Version": 1, "AccessKeyId": "a", "SecretAccessKey": "b", "Expiration": "` + expiration + `"}'`,
+	}
+	if provider.IsExpired() {
+		t.Errorf("expected credentials which have not been retrieved not to have an expiry")
+	}
+	if _, err := provider.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+	if !provider.IsExpired() {
+		t.Errorf("expected the credentials about to expire to be expired")
+	}
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// newPushCommand creates a new push command
+//
+func newPushCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "push",
+		Aliases:   []string{"sync"},
+		Usage:     "uploads only the new or changed files from one or more local paths into the bucket",
+		ArgsUsage: "PATH...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the aws kms id to use when performing operations",
+				EnvVar: "AWS_KMS_ID",
+			},
+			keyPathFlag,
+			keyFlattenFlag,
+			keyPrefixFlag,
+			cli.BoolFlag{
+				Name:  "envelope",
+				Usage: "encrypt the files client side with a kms data key rather than using s3 server side encryption",
+			},
+			cli.BoolFlag{
+				Name:  "delete",
+				Usage: "remove any keys in the bucket under the paths which no longer exist locally",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "report the changes which would be made without uploading or deleting anything",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s", "l:kms:s"}, cmd, pushFiles)
+		},
+	}
+}
+
+//
+// pushFiles uploads the local files whose content differs from the bucket, optionally removing any
+// keys which no longer exist locally
+//
+func pushFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	kms := cx.String("kms")
+	flatten := cx.Bool("flatten")
+	path := cx.String("path")
+	envelope := cx.Bool("envelope")
+	remove := cx.Bool("delete")
+	dryRun := cx.Bool("dry-run")
+
+	keyName, err := keyNameResolver(cx)
+	if err != nil {
+		return err
+	}
+	if remove && flatten {
+		return fmt.Errorf("invalid option, you cannot delete keys when flattening the files")
+	}
+	if len(cx.Args()) <= 0 {
+		return fmt.Errorf("you have not specified any files to upload")
+	}
+
+	// step: ensure the bucket exists
+	if found, err := cmd.hasBucket(bucket); err != nil {
+		return err
+	} else if !found {
+		return fmt.Errorf("the bucket: %s does not exist", bucket)
+	}
+
+	summary := map[string]int{"new": 0, "modified": 0, "unchanged": 0, "deleted": 0}
+	// step: the keys which exist locally
+	keys := make(map[string]bool, 0)
+
+	for _, p := range getPaths(cx) {
+		files, err := expandFiles(p)
+		if err != nil {
+			return fmt.Errorf("failed to process path: %s, error: %s", p, err)
+		}
+		for _, filename := range files {
+			keyName := keyName(filename)
+			keys[keyName] = true
+
+			content, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			// step: compare the content with the bucket, a missing key is a new file
+			status := "modified"
+			head, err := cmd.getFileMetadata(keyName, bucket)
+			if err != nil {
+				if !isNoSuchKey(err) {
+					return fmt.Errorf("unable to retrieve the file: %s, error: %s", keyName, err)
+				}
+				status = "new"
+			}
+			if status == "modified" {
+				matched, err := cmd.matchFile(bucket, keyName, head, content)
+				if err != nil {
+					return fmt.Errorf("unable to retrieve the file: %s, error: %s", keyName, err)
+				}
+				if matched {
+					status = "unchanged"
+				}
+			}
+			summary[status]++
+			if status == "unchanged" {
+				continue
+			}
+
+			if !dryRun {
+				if err := cmd.uploadFile(bucket, keyName, bytes.NewReader(content), kms, envelope); err != nil {
+					return fmt.Errorf("failed to put the file: %s, error: %s", filename, err)
+				}
+			}
+			o.fields(map[string]interface{}{
+				"action":  "push",
+				"path":    filename,
+				"bucket":  bucket,
+				"key":     keyName,
+				"status":  status,
+				"dry-run": dryRun,
+			}).log("%s the %s file: %s to s3://%s/%s\n", pushVerb(dryRun, "pushed", "push"), status, filename, bucket, keyName)
+		}
+	}
+
+	// step: remove any keys under the paths which no longer exist locally
+	if remove {
+		for _, p := range getPaths(cx) {
+			prefix, delimiter, err := pushPrefix(p, path, cx.String("prefix"))
+			if err != nil {
+				return err
+			}
+			var deleted []string
+			err = cmd.walkBucketKeys(bucket, prefix, delimiter, func(x *s3.Object) error {
+				if !keys[*x.Key] {
+					deleted = append(deleted, *x.Key)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, key := range deleted {
+				if !dryRun {
+					if err := cmd.removeFile(bucket, key); err != nil {
+						return fmt.Errorf("failed to delete the file: %s, error: %s", key, err)
+					}
+				}
+				// step: ensure we don't attempt to remove the key again under another path
+				keys[key] = true
+				summary["deleted"]++
+
+				o.fields(map[string]interface{}{
+					"action":  "delete",
+					"bucket":  bucket,
+					"key":     key,
+					"dry-run": dryRun,
+				}).log("%s the file: s3://%s/%s as it no longer exists locally\n", pushVerb(dryRun, "deleted", "delete"), bucket, key)
+			}
+		}
+	}
+
+	o.fields(map[string]interface{}{
+		"action":    "push-summary",
+		"bucket":    bucket,
+		"new":       summary["new"],
+		"modified":  summary["modified"],
+		"unchanged": summary["unchanged"],
+		"deleted":   summary["deleted"],
+		"dry-run":   dryRun,
+	}).log("%d new, %d modified, %d unchanged, %d deleted\n", summary["new"], summary["modified"], summary["unchanged"], summary["deleted"])
+
+	return nil
+}
+
+//
+// matchFile checks if the content is that of the file in the bucket, using the checksum of the file when it
+// has one, else retrieving the file and comparing the content
+//
+func (r *cliCommand) matchFile(bucket, key string, head *s3.HeadObjectOutput, content []byte) (bool, error) {
+	if matched, verified := r.matchChecksum(head, bytes.NewReader(content)); verified {
+		return matched, nil
+	}
+	remote, err := r.getFile(bucket, key)
+	if err != nil {
+		return false, err
+	}
+	defer zeroBytes(remote)
+
+	return bytes.Equal(content, remote), nil
+}
+
+//
+// pushPrefix returns the prefix in the bucket the files under the local path are uploaded to, beneath the
+// key prefix if any
+//
+func pushPrefix(localPath, path, keyPrefix string) (string, string, error) {
+	if path != "" {
+		return prefixKey(keyPrefix, strings.TrimRight(path, "/")) + "/", "/", nil
+	}
+	if found, err := isDirectory(localPath); err != nil {
+		return "", "", err
+	} else if !found {
+		return "", "", fmt.Errorf("the path: %s must be a directory when deleting keys", localPath)
+	}
+	// step: the local files are keyed by their cleaned path
+	prefix := filepath.ToSlash(filepath.Clean(localPath))
+	if prefix == "." {
+		prefix = ""
+	}
+	if prefix = prefixKey(keyPrefix, prefix); prefix == "" {
+		return "", "", nil
+	}
+
+	return strings.TrimRight(prefix, "/") + "/", "", nil
+}
+
+//
+// pushVerb returns the wording for the action depending on whether this is a dry run
+//
+func pushVerb(dryRun bool, done, planned string) string {
+	if dryRun {
+		return "would " + planned
+	}
+
+	return done
+}
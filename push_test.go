@@ -0,0 +1,187 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestPushFiles(t *testing.T) {
+	dir, cleanup := newTestDir(t, map[string]string{
+		"app/new.env":       "NEW=1\n",
+		"app/modified.env":  "MODIFIED=2\n",
+		"app/unchanged.env": "UNCHANGED=1\n",
+	})
+	defer cleanup()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		args    []string
+		summary string
+		keys    []string
+		error   bool
+	}{
+		{
+			args:    []string{"app"},
+			summary: "1 new, 1 modified, 1 unchanged, 0 deleted",
+			keys:    []string{"app/deleted.env", "app/modified.env", "app/new.env", "app/unchanged.env", "other.env"},
+		},
+		{
+			args:    []string{"--delete", "app"},
+			summary: "1 new, 1 modified, 1 unchanged, 1 deleted",
+			keys:    []string{"app/modified.env", "app/new.env", "app/unchanged.env", "other.env"},
+		},
+		{
+			args:    []string{"--delete", "--dry-run", "app"},
+			summary: "1 new, 1 modified, 1 unchanged, 1 deleted",
+			keys:    []string{"app/deleted.env", "app/modified.env", "app/unchanged.env", "other.env"},
+		},
+		{
+			args:    []string{"--prefix", "team", "app/new.env"},
+			summary: "1 new, 0 modified, 0 unchanged, 0 deleted",
+			keys:    []string{"app/deleted.env", "app/modified.env", "app/unchanged.env", "other.env", "team/app/new.env"},
+		},
+		{
+			args:  []string{"--delete", "--flatten", "app"},
+			error: true,
+		},
+		{
+			args:  []string{"--delete", "app/new.env"},
+			error: true,
+		},
+		{
+			args:  []string{},
+			error: true,
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		putTestFile(t, cmd, "app/modified.env", "MODIFIED=1\n", false)
+		putTestFile(t, cmd, "app/unchanged.env", "UNCHANGED=1\n", true)
+		putTestFile(t, cmd, "app/deleted.env", "DELETED=1\n", false)
+		putTestFile(t, cmd, "other.env", "OTHER=1\n", false)
+
+		args := append([]string{"--bucket", testBucket, "--kms", testKMS}, c.args...)
+		output, err := runTestCommand(t, cmd, newPushCommand(cmd), pushFiles, args...)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if !strings.HasSuffix(output, c.summary+"\n") {
+			t.Errorf("case %d: expected the summary: %q, got: %q", i, c.summary, output)
+		}
+		keys := testKeys(t, cmd)
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("case %d: expected the keys: %v, got: %v", i, c.keys, keys)
+		}
+	}
+}
+
+func TestMatchFile(t *testing.T) {
+	cases := []struct {
+		content  string
+		envelope bool
+		// the upload cannot seek, so has no checksum
+		stream   bool
+		local    string
+		matched  bool
+		verified bool
+	}{
+		{content: "A=1\n", local: "A=1\n", matched: true, verified: true},
+		{content: "A=1\n", local: "A=2\n", verified: true},
+		{content: "A=1\n", envelope: true, local: "A=1\n", matched: true, verified: true},
+		{content: "A=1\n", envelope: true, local: "A=2\n", verified: true},
+		{content: "A=1\n", stream: true, local: "A=1\n", matched: true},
+		{content: "A=1\n", stream: true, local: "A=2\n"},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		var body io.Reader = strings.NewReader(c.content)
+		if c.stream {
+			body = io.MultiReader(body)
+		}
+		if err := cmd.uploadFile(testBucket, "a.env", body, testKMS, c.envelope); err != nil {
+			t.Fatal(err)
+		}
+		head, err := cmd.getFileMetadata("a.env", testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, verified := cmd.matchChecksum(head, strings.NewReader(c.local)); verified != c.verified {
+			t.Errorf("case %d: expected the checksum verified: %t, got: %t", i, c.verified, verified)
+		}
+		matched, err := cmd.matchFile(testBucket, "a.env", head, []byte(c.local))
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if matched != c.matched {
+			t.Errorf("case %d: expected matched: %t, got: %t", i, c.matched, matched)
+		}
+		if content := getTestFile(t, cmd, "a.env"); content != c.content {
+			t.Errorf("case %d: expected the content: %q, got: %q", i, c.content, content)
+		}
+	}
+}
+
+func TestChecksumKeyed(t *testing.T) {
+	cmd := newTestCommand(t)
+	var checksums []string
+	for _, key := range []string{"a.env", "b.env"} {
+		putTestFile(t, cmd, key, "A=1\n", false)
+		head, err := cmd.getFileMetadata(key, testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checksum, _ := getMetadata(head.Metadata, checksumHeader)
+		checksums = append(checksums, checksum)
+	}
+	// step: the same content has a different checksum under each key, so it cannot be guessed
+	if checksums[0] == "" || checksums[0] == checksums[1] {
+		t.Errorf("expected the checksums to differ, got: %v", checksums)
+	}
+
+	// step: the checksum remains verifiable once the file is re-encrypted under another key
+	if _, err := runTestCommand(t, cmd, newRekeyCommand(cmd), rekeyFiles, "--bucket", testBucket, "--kms", "alias/other"); err != nil {
+		t.Fatal(err)
+	}
+	head, err := cmd.getFileMetadata("a.env", testBucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched, verified := cmd.matchChecksum(head, strings.NewReader("A=1\n")); !matched || !verified {
+		t.Errorf("expected the checksum to match after rekeying, matched: %t, verified: %t", matched, verified)
+	}
+}
@@ -16,7 +16,11 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -38,13 +42,26 @@ func newPutCommand(cmd *cliCommand) cli.Command {
 				Usage:  "the aws kms id to use when performing operations",
 				EnvVar: "AWS_KMS_ID",
 			},
-			cli.StringFlag{
-				Name:  "p, path",
-				Usage: "use this are the path inside the bucket, rather than the path to the file",
-			},
+			keyPathFlag,
+			keyFlattenFlag,
+			keyPrefixFlag,
 			cli.BoolFlag{
-				Name:  "flatten",
-				Usage: "do not maintain the directory structure, flatten all files into a single directory",
+				Name:  "envelope",
+				Usage: "encrypt the files client side with a kms data key rather than using s3 server side encryption",
+			},
+			cli.IntFlag{
+				Name:  "concurrency",
+				Usage: "the number of files to upload in parallel",
+				Value: 1,
+			},
+			cli.BoolTFlag{
+				Name:  "fail-fast",
+				Usage: "stop uploading at the first failure, rather than continuing and reporting all the failures (default true)",
+			},
+			cli.StringFlag{
+				Name:  "validate",
+				Usage: "validate the content before uploading, auto selects by extension, none disables or one of json, yaml, env, pem, toml",
+				Value: "auto",
 			},
 		},
 		Action: func(cx *cli.Context) error {
@@ -59,11 +76,19 @@ func newPutCommand(cmd *cliCommand) cli.Command {
 func putFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 	bucket := cx.String("bucket")
 	kms := cx.String("kms")
-	flatten := cx.Bool("flatten")
 	path := cx.String("path")
+	envelope := cx.Bool("envelope")
+	validate := cx.String("validate")
 
-	if flatten && path != "" {
-		return fmt.Errorf("invalid option, you cannot flatten *and* specify a path")
+	keyName, err := keyNameResolver(cx)
+	if err != nil {
+		return err
+	}
+	// step: stdin is uploaded to the key given by the path, so cannot be mixed with files
+	for _, x := range cx.Args() {
+		if x == "-" && len(cx.Args()) > 1 {
+			return fmt.Errorf("invalid option, you cannot read from stdin *and* upload files")
+		}
 	}
 
 	// step: ensure the bucket exists
@@ -78,38 +103,175 @@ func putFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
 		return fmt.Errorf("you have not specified any files to upload")
 	}
 
-	// step: iterate the paths and upload the files
+	// step: iterate the paths and queue the files for upload
+	var tasks []func(*formatter) error
 	for _, p := range getPaths(cx) {
+		// step: are we reading the content from stdin?
+		if p == "-" {
+			task, err := putStdin(bucket, prefixKey(cx.String("prefix"), path), kms, envelope, validate, cmd)
+			if err != nil {
+				return err
+			}
+			tasks = append(tasks, task)
+			continue
+		}
 		// step: get a list of files under this path
 		files, err := expandFiles(p)
 		if err != nil {
 			return fmt.Errorf("failed to process path: %s, error: %s", p, err)
 		}
 		// step: iterate the files in the path
-		for _, filename := range files {
+		for _, x := range files {
+			filename := x
 			// step: construct the key for this file
-			keyName := filename
-			if flatten {
-				keyName = filepath.Base(keyName)
+			keyName := keyName(filename)
+			// step: validate the content up front, so an invalid file means nothing is uploaded
+			check, err := selectValidator(validate, filename)
+			if err != nil {
+				return err
 			}
-			if path != "" {
-				keyName = fmt.Sprintf("%s/%s", strings.TrimRight(path, "/"), filepath.Base(keyName))
+			if err := validateFile(check, filename); err != nil {
+				return err
 			}
 
-			// step: upload the file to the bucket
-			if err := cmd.putFile(bucket, keyName, filename, kms); err != nil {
-				return fmt.Errorf("failed to put the file: %s, error: %s", filename, err)
-			}
+			tasks = append(tasks, func(o *formatter) error {
+				// step: upload the file to the bucket
+				if err := cmd.putFile(bucket, keyName, filename, kms, envelope); err != nil {
+					return fmt.Errorf("failed to put the file: %s, error: %s", filename, err)
+				}
+
+				// step: add the log
+				o.fields(map[string]interface{}{
+					"action": "put",
+					"path":   filename,
+					"bucket": bucket,
+					"key":    keyName,
+				}).log("successfully pushed the file: %s to s3://%s/%s\n", filename, bucket, keyName)
+
+				return nil
+			})
+		}
+	}
+
+	// step: upload the files over the worker pool
+	return aggregateErrors(runTasks(o, cx.Int("concurrency"), cx.BoolT("fail-fast"), tasks))
+}
+
+//
+// putStdin returns a task streaming the content of stdin to the key given by the path
+//
+func putStdin(bucket, path, kms string, envelope bool, validate string, cmd *cliCommand) (func(*formatter) error, error) {
+	if path == "" || strings.HasSuffix(path, "/") {
+		return nil, fmt.Errorf("you must specify the key name via --path when reading from stdin")
+	}
+	check, err := selectValidator(validate, path)
+	if err != nil {
+		return nil, err
+	}
 
-			// step: add the log
-			o.fields(map[string]interface{}{
-				"action": "put",
-				"path":   filename,
-				"bucket": bucket,
-				"key":    keyName,
-			}).log("successfully pushed the file: %s to s3://%s/%s\n", filename, bucket, keyName)
+	return func(o *formatter) error {
+		// step: the content is buffered when it must be validated before the upload
+		var body io.Reader = os.Stdin
+		if check != nil {
+			content, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("failed to read stdin, error: %s", err)
+			}
+			if err := check(content); err != nil {
+				return fmt.Errorf("the content of stdin is invalid, %s", err)
+			}
+			body = bytes.NewReader(content)
+		}
+		if err := cmd.uploadFile(bucket, path, body, kms, envelope); err != nil {
+			return fmt.Errorf("failed to put the content of stdin, error: %s", err)
 		}
+
+		o.fields(map[string]interface{}{
+			"action": "put",
+			"path":   "-",
+			"bucket": bucket,
+			"key":    path,
+		}).log("successfully pushed stdin to s3://%s/%s\n", bucket, path)
+
+		return nil
+	}, nil
+}
+
+var (
+	// keyPathFlag places the files under a path in the bucket, shared by the commands resolving keys as put does
+	keyPathFlag = cli.StringFlag{
+		Name:  "p, path",
+		Usage: "use this are the path inside the bucket, rather than the path to the file",
+	}
+	// keyFlattenFlag places the files by their name alone, shared by the commands resolving keys as put does
+	keyFlattenFlag = cli.BoolFlag{
+		Name:  "flatten",
+		Usage: "do not maintain the directory structure, flatten all files into a single directory",
+	}
+	// keyPrefixFlag places the keys under a prefix, shared by the commands resolving keys as put does
+	keyPrefixFlag = cli.StringFlag{
+		Name:   "prefix",
+		Usage:  "a prefix inside the bucket the keys are placed under, retaining the path of each file beneath it",
+		EnvVar: "S3SECRETS_PREFIX",
+	}
+)
+
+//
+// keyNameResolver returns a method constructing the key of a local file from the path, flatten and prefix
+// options
+//
+func keyNameResolver(cx *cli.Context) (func(string) string, error) {
+	flatten := cx.Bool("flatten")
+	path := cx.String("path")
+	prefix := cx.String("prefix")
+
+	if flatten && path != "" {
+		return nil, fmt.Errorf("invalid option, you cannot flatten *and* specify a path")
+	}
+
+	return func(filename string) string {
+		return prefixKey(prefix, fileKeyName(filename, path, flatten))
+	}, nil
+}
+
+//
+// prefixKey places the key under the prefix, if any
+//
+func prefixKey(prefix, key string) string {
+	if prefix = strings.Trim(prefix, "/"); prefix == "" {
+		return key
+	}
+
+	return prefix + "/" + strings.TrimLeft(key, "/")
+}
+
+//
+// fileKeyName constructs the key in the bucket for a local file, either the path to the file, it's name
+// when flattening or it's name under the path
+//
+func fileKeyName(filename, path string, flatten bool) string {
+	keyName := filename
+	if flatten {
+		keyName = filepath.Base(keyName)
+	}
+	if path != "" {
+		keyName = fmt.Sprintf("%s/%s", strings.TrimRight(path, "/"), filepath.Base(keyName))
+	}
+
+	return keyName
+}
+
+//
+// validateFile validates the content of a local file prior to uploading
+//
+func validateFile(check validator, filename string) error {
+	if check == nil {
+		return nil
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read the file: %s, error: %s", filename, err)
 	}
 
-	return nil
+	return validateContent(check, filename, content)
 }
@@ -0,0 +1,159 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPutFiles(t *testing.T) {
+	dir, cleanup := newTestDir(t, map[string]string{
+		"app/config.json": `{"password": "secret"}`,
+		"app/app.env":     "PASSWORD=secret\n",
+		"bad.json":        `{"password": `,
+	})
+	defer cleanup()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		args  []string
+		keys  []string
+		error bool
+	}{
+		{
+			args: []string{"--bucket", testBucket, "--kms", testKMS, "app"},
+			keys: []string{"app/app.env", "app/config.json"},
+		},
+		{
+			args: []string{"--bucket", testBucket, "--kms", testKMS, "--flatten", "app"},
+			keys: []string{"app.env", "config.json"},
+		},
+		{
+			args: []string{"--bucket", testBucket, "--kms", testKMS, "--path", "prod", "app/config.json"},
+			keys: []string{"prod/config.json"},
+		},
+		{
+			args: []string{"--bucket", testBucket, "--kms", testKMS, "--prefix", "team/", "app/app.env"},
+			keys: []string{"team/app/app.env"},
+		},
+		{
+			args: []string{"--bucket", testBucket, "--kms", testKMS, "--envelope", "--concurrency", "2", "app"},
+			keys: []string{"app/app.env", "app/config.json"},
+		},
+		{
+			args: []string{"--bucket", testBucket, "--kms", testKMS, "--validate", "none", "bad.json"},
+			keys: []string{"bad.json"},
+		},
+		{
+			args:  []string{"--bucket", testBucket, "--kms", testKMS, "bad.json"},
+			error: true,
+		},
+		{
+			args:  []string{"--bucket", testBucket, "--kms", testKMS, "--validate", "json", "app/app.env"},
+			error: true,
+		},
+		{
+			args:  []string{"--bucket", testBucket, "--kms", testKMS, "--validate", "xml", "app"},
+			error: true,
+		},
+		{
+			args:  []string{"--bucket", testBucket, "--kms", testKMS, "--flatten", "--path", "prod", "app"},
+			error: true,
+		},
+		{
+			args:  []string{"--bucket", "missing", "--kms", testKMS, "app"},
+			error: true,
+		},
+		{
+			args:  []string{"--bucket", testBucket, "--kms", testKMS},
+			error: true,
+		},
+		{
+			args:  []string{"--bucket", testBucket, "--kms", testKMS, "-", "app"},
+			error: true,
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		_, err := runTestCommand(t, cmd, newPutCommand(cmd), putFiles, c.args...)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		keys := testKeys(t, cmd)
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("case %d: expected the keys: %v, got: %v", i, c.keys, keys)
+		}
+	}
+}
+
+func TestPutFilesContent(t *testing.T) {
+	dir, cleanup := newTestDir(t, map[string]string{"app.env": "PASSWORD=secret\n"})
+	defer cleanup()
+
+	for _, envelope := range []string{"--envelope=false", "--envelope"} {
+		cmd := newTestCommand(t)
+		if _, err := runTestCommand(t, cmd, newPutCommand(cmd), putFiles,
+			"--bucket", testBucket, "--kms", testKMS, "--flatten", envelope, dir+"/app.env"); err != nil {
+			t.Fatalf("case %s: unexpected error: %s", envelope, err)
+		}
+		if content := getTestFile(t, cmd, "app.env"); content != "PASSWORD=secret\n" {
+			t.Errorf("case %s: unexpected content: %q", envelope, content)
+		}
+	}
+}
+
+func TestKeyNameResolver(t *testing.T) {
+	cases := []struct {
+		args     []string
+		filename string
+		key      string
+	}{
+		{filename: "app/config.json", key: "app/config.json"},
+		{args: []string{"--flatten"}, filename: "app/config.json", key: "config.json"},
+		{args: []string{"--path", "prod/"}, filename: "app/config.json", key: "prod/config.json"},
+		{args: []string{"--prefix", "/team/"}, filename: "app/config.json", key: "team/app/config.json"},
+		{args: []string{"--prefix", "team", "--flatten"}, filename: "app/config.json", key: "team/config.json"},
+		{args: []string{"--prefix", "team", "--path", "prod"}, filename: "app/config.json", key: "team/prod/config.json"},
+	}
+	for i, c := range cases {
+		keyName, err := keyNameResolver(newTestContext(t, newPutCommand(nil), c.args...))
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if key := keyName(c.filename); key != c.key {
+			t.Errorf("case %d: expected the key: %s, got: %s", i, c.key, key)
+		}
+	}
+}
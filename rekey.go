@@ -0,0 +1,254 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// newRekeyCommand creates a new rekey command
+//
+func newRekeyCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "rekey",
+		Usage:     "re-encrypts the files under one or more prefixes in the bucket with a new kms key",
+		ArgsUsage: "[PREFIX...]",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:   "k, kms",
+				Usage:  "the aws kms id to re-encrypt the files with",
+				EnvVar: "AWS_KMS_ID",
+			},
+			cli.StringFlag{
+				Name:  "from-key",
+				Usage: "only re-encrypt the files presently encrypted with this kms id, arn or alias",
+			},
+			cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "report the files which would be re-encrypted without changing anything",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s", "l:kms:s"}, cmd, rekeyFiles)
+		},
+	}
+}
+
+//
+// rekeyFiles re-encrypts the files under the prefixes with the new kms key. Server side encrypted files
+// are copied in place by s3, while envelope encrypted files have only their data key re-encrypted by kms,
+// so neither the content nor the data key is ever decrypted on the client
+//
+func rekeyFiles(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	kmsID := cx.String("kms")
+	dryRun := cx.Bool("dry-run")
+
+	// step: resolve the keys we are moving from and to, so we can match them against the object metadata
+	targets, err := cmd.kmsKeyNames(kmsID)
+	if err != nil {
+		return err
+	}
+	var sources []string
+	if fromKey := cx.String("from-key"); fromKey != "" {
+		if sources, err = cmd.kmsKeyNames(fromKey); err != nil {
+			return err
+		}
+	}
+
+	// step: retrieve the keys under the prefixes, the entire bucket if none are given
+	prefixes := []string(cx.Args())
+	if len(prefixes) <= 0 {
+		prefixes = []string{""}
+	}
+	var keys []string
+	seen := make(map[string]bool, 0)
+	for _, prefix := range prefixes {
+		list, err := cmd.listBucketKeys(bucket, prefix)
+		if err != nil {
+			return err
+		}
+		for _, x := range list {
+			if !seen[*x.Key] {
+				seen[*x.Key] = true
+				keys = append(keys, *x.Key)
+			}
+		}
+	}
+
+	summary := map[string]int{"rekeyed": 0, "skipped": 0}
+	for i, key := range keys {
+		metadata, err := cmd.getFileMetadata(key, bucket)
+		if err != nil {
+			return fmt.Errorf("unable to retrieve the file: %s, error: %s", key, err)
+		}
+		current, envelope := objectEncryption(metadata)
+
+		// step: skip any files not under the old key or already under the new one
+		if (sources != nil && !matchKMSKey(current, sources)) || matchKMSKey(current, targets) {
+			summary["skipped"]++
+			continue
+		}
+
+		if !dryRun {
+			if err := cmd.rekeyFile(bucket, key, kmsID, metadata, envelope); err != nil {
+				return fmt.Errorf("failed to re-encrypt the file: %s, error: %s", key, err)
+			}
+		}
+		summary["rekeyed"]++
+
+		o.fields(map[string]interface{}{
+			"action":   "rekey",
+			"bucket":   bucket,
+			"key":      key,
+			"from-key": current,
+			"kms-id":   kmsID,
+			"envelope": envelope,
+			"dry-run":  dryRun,
+		}).log("[%d/%d] %s the file: s3://%s/%s from: %s to: %s\n", i+1, len(keys),
+			pushVerb(dryRun, "re-encrypted", "re-encrypt"), bucket, key, current, kmsID)
+	}
+
+	o.fields(map[string]interface{}{
+		"action":  "rekey-summary",
+		"bucket":  bucket,
+		"rekeyed": summary["rekeyed"],
+		"skipped": summary["skipped"],
+		"dry-run": dryRun,
+	}).log("%d re-encrypted, %d skipped\n", summary["rekeyed"], summary["skipped"])
+
+	return nil
+}
+
+//
+// rekeyFile copies the file in place under the new kms key, provided it's still the version inspected
+//
+func (r *cliCommand) rekeyFile(bucket, key, kmsID string, metadata *s3.HeadObjectOutput, envelope bool) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(copySource(bucket, key, "")),
+		CopySourceIfMatch: metadata.ETag,
+	}
+	if !envelope {
+		input.ServerSideEncryption = aws.String("aws:kms")
+		input.SSEKMSKeyId = aws.String(kmsID)
+		// step: the key of the checksum, if any, is wrapped by the kms key so must be re-encrypted too
+		if _, found := getMetadata(metadata.Metadata, checksumKeyHeader); !found {
+			return r.store.Copy(input)
+		}
+		updated, _, err := r.rekeyMetadata(metadata, checksumKeyHeader, kmsID)
+		if err != nil {
+			return err
+		}
+		input.Metadata = updated
+		input.MetadataDirective = aws.String(s3.MetadataDirectiveReplace)
+
+		return r.store.Copy(input)
+	}
+
+	// step: have kms re-encrypt the data key under the new key, recording the key id it reports
+	updated, keyID, err := r.rekeyMetadata(metadata, envelopeKeyHeader, kmsID)
+	if err != nil {
+		return err
+	}
+	for k := range updated {
+		if strings.EqualFold(k, envelopeKMSHeader) {
+			delete(updated, k)
+		}
+	}
+	updated[envelopeKMSHeader] = aws.String(keyID)
+	input.Metadata = updated
+	input.MetadataDirective = aws.String(s3.MetadataDirectiveReplace)
+	if metadata.ServerSideEncryption != nil {
+		input.ServerSideEncryption = metadata.ServerSideEncryption
+	}
+
+	return r.store.Copy(input)
+}
+
+//
+// rekeyMetadata has kms re-encrypt the wrapped key in the metadata header of the object under the new key,
+// returning a copy of the metadata with the key replaced and the key id kms reports
+//
+func (r *cliCommand) rekeyMetadata(head *s3.HeadObjectOutput, header, kmsID string) (map[string]*string, string, error) {
+	wrapped, err := decodeMetadata(head.Metadata, header)
+	if err != nil {
+		return nil, "", err
+	}
+	sourceKeyID, _ := objectEncryption(head)
+	wrapped, keyID, err := r.keyService.ReEncrypt(sourceKeyID, kmsID, wrapped)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to re-encrypt the data key, error: %s", err)
+	}
+
+	updated := make(map[string]*string, 0)
+	for k, v := range head.Metadata {
+		if !strings.EqualFold(k, header) {
+			updated[k] = v
+		}
+	}
+	updated[header] = aws.String(base64.StdEncoding.EncodeToString(wrapped))
+
+	return updated, keyID, nil
+}
+
+//
+// kmsKeyNames returns the names a kms key may be referred to by, resolving an alias to the key id
+//
+func (r *cliCommand) kmsKeyNames(kmsID string) ([]string, error) {
+	names := []string{kmsID}
+	if !strings.HasPrefix(kmsID, "alias/") {
+		return names, nil
+	}
+	aliases, err := r.kmsKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, x := range aliases {
+		if aws.StringValue(x.AliasName) == kmsID && x.TargetKeyId != nil {
+			names = append(names, aws.StringValue(x.TargetKeyId))
+		}
+	}
+
+	return names, nil
+}
+
+//
+// matchKMSKey checks if the kms key of an object is one of the names, s3 reports the key by arn
+// i.e. arn:aws:kms:region:account:key/id so we also match the suffix
+//
+func matchKMSKey(kmsID string, names []string) bool {
+	for _, x := range names {
+		if kmsID == x || strings.HasSuffix(kmsID, ":"+x) || strings.HasSuffix(kmsID, ":key/"+x) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRekeyFiles(t *testing.T) {
+	cases := []struct {
+		args    []string
+		summary string
+		// the keys expected to be encrypted with the new kms key
+		rekeyed []string
+	}{
+		{
+			args:    []string{"--kms", "alias/new"},
+			summary: "4 re-encrypted, 0 skipped",
+			rekeyed: []string{"a.txt", "dir/b.txt", "dir/c.txt", "other.txt"},
+		},
+		{
+			args:    []string{"--kms", "alias/new", "--dry-run"},
+			summary: "4 re-encrypted, 0 skipped",
+		},
+		{
+			args:    []string{"--kms", "alias/new", "--from-key", "alias/other"},
+			summary: "1 re-encrypted, 3 skipped",
+			rekeyed: []string{"other.txt"},
+		},
+		{
+			args:    []string{"--kms", "alias/new", "--from-key", testKMS, "dir/"},
+			summary: "2 re-encrypted, 0 skipped",
+			rekeyed: []string{"dir/b.txt", "dir/c.txt"},
+		},
+		{
+			args:    []string{"--kms", testKMS},
+			summary: "1 re-encrypted, 3 skipped",
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		putTestFile(t, cmd, "a.txt", "a", false)
+		putTestFile(t, cmd, "dir/b.txt", "b", true)
+		putTestFile(t, cmd, "dir/c.txt", "c", false)
+		if err := cmd.uploadFile(testBucket, "other.txt", bytes.NewBufferString("other"), "alias/other", true); err != nil {
+			t.Fatal(err)
+		}
+		// step: ensure the new key exists so it's alias can be resolved
+		if _, _, _, err := cmd.keyService.GenerateDataKey("alias/new"); err != nil {
+			t.Fatal(err)
+		}
+
+		args := append([]string{"--bucket", testBucket}, c.args...)
+		output, err := runTestCommand(t, cmd, newRekeyCommand(cmd), rekeyFiles, args...)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if !strings.HasSuffix(output, c.summary+"\n") {
+			t.Errorf("case %d: expected the summary: %q, got: %q", i, c.summary, output)
+		}
+
+		rekeyed := make(map[string]bool, 0)
+		for _, key := range c.rekeyed {
+			rekeyed[key] = true
+		}
+		for _, key := range testKeys(t, cmd) {
+			metadata, err := cmd.getFileMetadata(key, testBucket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			kmsID, _ := objectEncryption(metadata)
+			if found := matchKMSKey(kmsID, []string{"alias/new", "new"}); found != rekeyed[key] {
+				t.Errorf("case %d: expected the file: %s rekeyed: %t, the kms key is: %s", i, key, rekeyed[key], kmsID)
+			}
+			// step: the content must remain readable under the new key
+			if content := getTestFile(t, cmd, key); content != strings.TrimSuffix(key[strings.LastIndex(key, "/")+1:], ".txt") {
+				t.Errorf("case %d: unexpected content of the file: %s, %q", i, key, content)
+			}
+		}
+	}
+}
+
+func TestMatchKMSKey(t *testing.T) {
+	cases := []struct {
+		kmsID string
+		names []string
+		match bool
+	}{
+		{kmsID: "alias/test", names: []string{"alias/test"}, match: true},
+		{kmsID: "arn:aws:kms:eu-west-2:123456789012:key/1234", names: []string{"alias/test", "1234"}, match: true},
+		{kmsID: "arn:aws:kms:eu-west-2:123456789012:alias/test", names: []string{"alias/test"}, match: true},
+		{kmsID: "arn:aws:kms:eu-west-2:123456789012:key/11234", names: []string{"1234"}},
+		{kmsID: "", names: []string{"alias/test"}},
+	}
+	for i, c := range cases {
+		if match := matchKMSKey(c.kmsID, c.names); match != c.match {
+			t.Errorf("case %d: expected match: %t, got: %t", i, c.match, match)
+		}
+	}
+}
+
+func TestKMSKeyNames(t *testing.T) {
+	cases := []struct {
+		kmsID    string
+		expected []string
+	}{
+		{kmsID: "1234", expected: []string{"1234"}},
+		{kmsID: testKMS, expected: []string{testKMS, "test"}},
+		{kmsID: "alias/missing", expected: []string{"alias/missing"}},
+	}
+	cmd := newTestCommand(t)
+	putTestFile(t, cmd, "a.txt", "a", true)
+	for i, c := range cases {
+		names, err := cmd.kmsKeyNames(c.kmsID)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if strings.Join(names, ",") != strings.Join(c.expected, ",") {
+			t.Errorf("case %d: expected: %v, got: %v", i, c.expected, names)
+		}
+	}
+}
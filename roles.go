@@ -0,0 +1,270 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/urfave/cli"
+)
+
+// roleExpiryWindow is how long before the expiration we consider the temporary credentials expired
+const roleExpiryWindow = 5 * time.Minute
+
+// roleOptions are the options for assuming a role, optionally with a mfa token or a web identity token
+type roleOptions struct {
+	// the arn of the role to assume
+	roleARN string
+	// the identity of the credentials assuming the role, i.e. the source profile or access key
+	sourceID string
+	// the name of the session
+	sessionName string
+	// the external id required by the role
+	externalID string
+	// the serial or arn of the mfa device, we prompt for the code
+	mfaSerial string
+	// the file containing a web identity token
+	tokenFile string
+	// the duration of the session
+	duration time.Duration
+	// the directory to cache the credentials in, empty disables the cache
+	cacheDir string
+}
+
+// roleClient wraps the security token service, caching the temporary credentials on disk and
+// handling the mfa and web identity tokens
+type roleClient struct {
+	*roleOptions
+	// the security token service client
+	client *sts.STS
+}
+
+// cachedCredentials are the temporary credentials held in the cache
+type cachedCredentials struct {
+	AccessKeyID     string    `json:"access-key-id"`
+	SecretAccessKey string    `json:"secret-access-key"`
+	SessionToken    string    `json:"session-token"`
+	Expiration      time.Time `json:"expiration"`
+}
+
+//
+// newRoleOptions creates the role options from the command line
+//
+func newRoleOptions(cx *cli.Context) *roleOptions {
+	return &roleOptions{
+		roleARN:     cx.GlobalString("role-arn"),
+		sessionName: cx.GlobalString("role-session-name"),
+		externalID:  cx.GlobalString("external-id"),
+		mfaSerial:   cx.GlobalString("mfa-serial"),
+		tokenFile:   cx.GlobalString("web-identity-token-file"),
+		duration:    cx.GlobalDuration("role-duration"),
+		cacheDir:    cx.GlobalString("credentials-cache"),
+	}
+}
+
+//
+// assumeRole validates the options and returns the credentials for the role, the security token service
+// is called with the credentials in the config
+//
+func (r *roleOptions) assumeRole(config *aws.Config) (*credentials.Credentials, error) {
+	if r.tokenFile != "" && (r.mfaSerial != "" || r.externalID != "") {
+		return nil, fmt.Errorf("you cannot use a mfa device or external id with a web identity token")
+	}
+	if r.sessionName == "" {
+		r.sessionName = progName
+	}
+	if r.duration < 15*time.Minute {
+		return nil, fmt.Errorf("the role duration must be at least 15 minutes")
+	}
+
+	// step: the endpoint option is for s3 only, so is removed from the config for the service
+	stsConfig := config.Copy()
+	stsConfig.Endpoint = nil
+	client := &roleClient{roleOptions: r, client: sts.New(session.New(stsConfig))}
+
+	return stscreds.NewCredentialsWithClient(client, r.roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = r.sessionName
+		p.Duration = r.duration
+		p.ExternalID = optionalString(r.externalID)
+		p.ExpiryWindow = roleExpiryWindow
+	}), nil
+}
+
+//
+// AssumeRole returns the temporary credentials for the role, from the cache if they are still valid
+//
+func (r *roleClient) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	if cached, found := r.cached(); found {
+		return &sts.AssumeRoleOutput{
+			Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String(cached.AccessKeyID),
+				SecretAccessKey: aws.String(cached.SecretAccessKey),
+				SessionToken:    aws.String(cached.SessionToken),
+				Expiration:      aws.Time(cached.Expiration),
+			},
+		}, nil
+	}
+
+	creds, err := r.request(input)
+	if err != nil {
+		return nil, fmt.Errorf("unable to assume the role: %s, error: %s", r.roleARN, err)
+	}
+	if creds == nil || creds.AccessKeyId == nil || creds.SecretAccessKey == nil ||
+		creds.SessionToken == nil || creds.Expiration == nil {
+		return nil, fmt.Errorf("unable to assume the role: %s, no credentials were returned", r.roleARN)
+	}
+
+	// step: a failure to cache the credentials is not fatal, we'll simply request them again next time
+	if err := r.store(creds); err != nil {
+		fmt.Fprintf(os.Stderr, "[warn] unable to cache the credentials for the role: %s, error: %s\n", r.roleARN, err)
+	}
+
+	return &sts.AssumeRoleOutput{Credentials: creds}, nil
+}
+
+//
+// request asks the security token service for the temporary credentials
+//
+func (r *roleClient) request(input *sts.AssumeRoleInput) (*sts.Credentials, error) {
+	if r.tokenFile != "" {
+		token, err := ioutil.ReadFile(r.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the web identity token file: %s, error: %s", r.tokenFile, err)
+		}
+		// step: the token is the proof of identity, so the request is not signed with any credentials
+		req, resp := r.client.AssumeRoleWithWebIdentityRequest(&sts.AssumeRoleWithWebIdentityInput{
+			DurationSeconds:  input.DurationSeconds,
+			RoleArn:          input.RoleArn,
+			RoleSessionName:  input.RoleSessionName,
+			WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+		})
+		req.Config.Credentials = credentials.AnonymousCredentials
+		if err := req.Send(); err != nil {
+			return nil, err
+		}
+		return resp.Credentials, nil
+	}
+
+	if r.mfaSerial != "" {
+		code, err := readMFACode(r.mfaSerial)
+		if err != nil {
+			return nil, err
+		}
+		input.SerialNumber = aws.String(r.mfaSerial)
+		input.TokenCode = aws.String(code)
+	}
+	resp, err := r.client.AssumeRole(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Credentials, nil
+}
+
+//
+// cachePath returns the path of the cache file, keyed by everything which identifies the session
+//
+func (r *roleOptions) cachePath() string {
+	checksum := sha256.Sum256([]byte(strings.Join([]string{
+		r.sourceID, r.roleARN, r.sessionName, r.externalID, r.mfaSerial, r.tokenFile}, "\x00")))
+
+	return filepath.Join(r.cacheDir, hex.EncodeToString(checksum[:16])+".json")
+}
+
+//
+// cached retrieves the credentials from the cache if present and not about to expire
+//
+func (r *roleOptions) cached() (*cachedCredentials, bool) {
+	if r.cacheDir == "" {
+		return nil, false
+	}
+	content, err := ioutil.ReadFile(r.cachePath())
+	if err != nil {
+		return nil, false
+	}
+	cached := &cachedCredentials{}
+	if err := json.Unmarshal(content, cached); err != nil {
+		return nil, false
+	}
+	if time.Now().Add(roleExpiryWindow).After(cached.Expiration) {
+		return nil, false
+	}
+
+	return cached, true
+}
+
+//
+// store writes the credentials into the cache, only readable by us
+//
+func (r *roleOptions) store(creds *sts.Credentials) error {
+	if r.cacheDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(r.cacheDir, 0700); err != nil {
+		return err
+	}
+	content, err := json.Marshal(&cachedCredentials{
+		AccessKeyID:     aws.StringValue(creds.AccessKeyId),
+		SecretAccessKey: aws.StringValue(creds.SecretAccessKey),
+		SessionToken:    aws.StringValue(creds.SessionToken),
+		Expiration:      aws.TimeValue(creds.Expiration),
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeFile(r.cachePath(), content, defaultFileOptions(0600))
+}
+
+//
+// readMFACode prompts for the code of the mfa device, using the terminal if we have one so stdin
+// remains free for the commands reading from it
+//
+func readMFACode(serial string) (string, error) {
+	var reader io.Reader = os.Stdin
+	var writer io.Writer = os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		reader, writer = tty, tty
+	}
+
+	fmt.Fprintf(writer, "enter the mfa code for %s: ", serial)
+	code, err := bufio.NewReader(reader).ReadString('\n')
+	code = strings.TrimSpace(code)
+	if code == "" {
+		if err != nil {
+			return "", fmt.Errorf("unable to read the mfa code, error: %s", err)
+		}
+		return "", fmt.Errorf("no mfa code was given")
+	}
+
+	return code, nil
+}
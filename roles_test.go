@@ -0,0 +1,169 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+//
+// newTestSTS creates a security token service answering the requests with temporary credentials, recording
+// the actions requested and whether they were signed
+//
+func newTestSTS(t *testing.T) (*sts.STS, map[string]bool, func()) {
+	signed := make(map[string]bool, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		action := r.Form.Get("Action")
+		signed[action] = r.Header.Get("Authorization") != ""
+		fmt.Fprintf(w, `<%sResponse><%sResult><Credentials>
+			<AccessKeyId>role-key</AccessKeyId>
+			<SecretAccessKey>role-secret</SecretAccessKey>
+			<SessionToken>role-token</SessionToken>
+			<Expiration>%s</Expiration>
+			</Credentials></%sResult></%sResponse>`,
+			action, action, time.Now().Add(time.Hour).UTC().Format(time.RFC3339), action, action)
+	}))
+	client := sts.New(session.New(&aws.Config{
+		Credentials: credentials.NewStaticCredentials("source-key", "source-secret", ""),
+		Endpoint:    aws.String(server.URL),
+		Region:      aws.String("us-east-1"),
+	}))
+
+	return client, signed, server.Close
+}
+
+func TestAssumeRoleOptions(t *testing.T) {
+	cases := []struct {
+		options *roleOptions
+		error   bool
+	}{
+		{options: &roleOptions{roleARN: "arn:aws:iam::1:role/a", duration: time.Hour}},
+		{options: &roleOptions{roleARN: "arn:aws:iam::1:role/a", duration: time.Minute}, error: true},
+		{options: &roleOptions{roleARN: "arn:aws:iam::1:role/a", duration: time.Hour, tokenFile: "token", mfaSerial: "mfa"}, error: true},
+		{options: &roleOptions{roleARN: "arn:aws:iam::1:role/a", duration: time.Hour, tokenFile: "token", externalID: "id"}, error: true},
+	}
+	for i, c := range cases {
+		_, err := c.options.assumeRole(&aws.Config{Region: aws.String("us-east-1")})
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if c.options.sessionName != progName {
+			t.Errorf("case %d: expected the session name to default to: %s, got: %s", i, progName, c.options.sessionName)
+		}
+	}
+}
+
+func TestAssumeRoleRequest(t *testing.T) {
+	dir, cleanup := newTestDir(t, map[string]string{"token": "web-identity-token\n"})
+	defer cleanup()
+
+	cases := []struct {
+		tokenFile string
+		action    string
+		signed    bool
+	}{
+		{action: "AssumeRole", signed: true},
+		{tokenFile: filepath.Join(dir, "token"), action: "AssumeRoleWithWebIdentity"},
+	}
+	for i, c := range cases {
+		client, signed, closer := newTestSTS(t)
+		r := &roleClient{roleOptions: &roleOptions{roleARN: "arn:aws:iam::1:role/a", tokenFile: c.tokenFile}, client: client}
+		resp, err := r.AssumeRole(&sts.AssumeRoleInput{
+			RoleArn:         aws.String(r.roleARN),
+			RoleSessionName: aws.String("test"),
+		})
+		closer()
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if key := aws.StringValue(resp.Credentials.AccessKeyId); key != "role-key" {
+			t.Errorf("case %d: expected the access key: role-key, got: %s", i, key)
+		}
+		if found, requested := signed[c.action]; !requested || found != c.signed {
+			t.Errorf("case %d: expected the action: %s, signed: %t, got: %v", i, c.action, c.signed, signed)
+		}
+	}
+}
+
+func TestRoleCache(t *testing.T) {
+	dir, cleanup := newTestDir(t, nil)
+	defer cleanup()
+
+	options := &roleOptions{roleARN: "arn:aws:iam::1:role/a", sourceID: "profile:a", cacheDir: dir}
+	if _, found := options.cached(); found {
+		t.Fatalf("expected the cache to be empty")
+	}
+	creds := &sts.Credentials{
+		AccessKeyId:     aws.String("cached-key"),
+		SecretAccessKey: aws.String("cached-secret"),
+		SessionToken:    aws.String("cached-token"),
+		Expiration:      aws.Time(time.Now().Add(time.Hour)),
+	}
+	if err := options.store(creds); err != nil {
+		t.Fatal(err)
+	}
+
+	// step: the cached credentials are used rather than the service
+	r := &roleClient{roleOptions: options}
+	resp, err := r.AssumeRole(&sts.AssumeRoleInput{RoleArn: aws.String(options.roleARN)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := aws.StringValue(resp.Credentials.AccessKeyId); key != "cached-key" {
+		t.Errorf("expected the cached access key, got: %s", key)
+	}
+
+	// step: the credentials of another source, session or role are not shared
+	for i, x := range []*roleOptions{
+		{roleARN: options.roleARN, sourceID: "profile:b", cacheDir: dir},
+		{roleARN: options.roleARN, sourceID: options.sourceID, sessionName: "other", cacheDir: dir},
+		{roleARN: "arn:aws:iam::1:role/b", sourceID: options.sourceID, cacheDir: dir},
+	} {
+		if _, found := x.cached(); found {
+			t.Errorf("case %d: expected the cached credentials not to be used", i)
+		}
+	}
+
+	// step: credentials about to expire are not used
+	creds.Expiration = aws.Time(time.Now().Add(roleExpiryWindow / 2))
+	if err := options.store(creds); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := options.cached(); found {
+		t.Errorf("expected the expiring credentials not to be used")
+	}
+}
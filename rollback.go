@@ -0,0 +1,97 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/urfave/cli"
+)
+
+//
+// newRollbackCommand creates a new rollback command
+//
+func newRollbackCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "rollback",
+		Usage:     "restores a previous version of a file as the current version",
+		ArgsUsage: "KEY",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "version-id",
+				Usage: "the version of the file to restore, see list --versions",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s", "l:version-id:s"}, cmd, rollbackFile)
+		},
+	}
+}
+
+//
+// rollbackFile copies a previous version of the file back as the current version
+//
+func rollbackFile(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	versionID := cx.String("version-id")
+
+	if len(cx.Args()) != 1 {
+		return fmt.Errorf("you must specify a single file to rollback")
+	}
+	key := cx.Args().First()
+
+	// step: retrieve the metadata of the version, ensuring it exists
+	metadata, err := cmd.getFileVersionMetadata(key, bucket, versionID)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve version: %s of the file: %s, error: %s", versionID, key, err)
+	}
+	kmsID, envelope := objectEncryption(metadata)
+	if kmsID == "" {
+		return fmt.Errorf("unable to determine the kms key used to encrypt the file: %s", key)
+	}
+
+	// step: copy the version in place, envelope encrypted files carry their key in the metadata while
+	// server side encrypted files must be re-encrypted with the same kms key
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String(key),
+		CopySource: aws.String(copySource(bucket, key, versionID)),
+	}
+	if !envelope {
+		input.ServerSideEncryption = aws.String("aws:kms")
+		input.SSEKMSKeyId = aws.String(kmsID)
+	}
+	if err := cmd.store.Copy(input); err != nil {
+		return err
+	}
+
+	o.fields(map[string]interface{}{
+		"action":     "rollback",
+		"bucket":     bucket,
+		"key":        key,
+		"version-id": versionID,
+		"kms-id":     kmsID,
+	}).log("successfully restored version: %s of the file: s3://%s/%s\n", versionID, bucket, key)
+
+	return nil
+}
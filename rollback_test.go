@@ -0,0 +1,71 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRollbackFile(t *testing.T) {
+	cases := []struct {
+		key      string
+		envelope bool
+		// the arguments, with the version id of the first version substituted for first
+		args  []string
+		error bool
+	}{
+		{key: "a.txt", args: []string{"--version-id", "first", "a.txt"}},
+		{key: "a.txt", envelope: true, args: []string{"--version-id", "first", "a.txt"}},
+		{key: "dir/a b+c.txt", args: []string{"--version-id", "first", "dir/a b+c.txt"}},
+		{key: "dir/a b+c.txt", envelope: true, args: []string{"--version-id", "first", "dir/a b+c.txt"}},
+		{key: "a.txt", args: []string{"--version-id", "missing", "a.txt"}, error: true},
+		{key: "a.txt", args: []string{"--version-id", "first", "missing.txt"}, error: true},
+		{key: "a.txt", args: []string{"--version-id", "first", "a.txt", "b.txt"}, error: true},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		putTestFile(t, cmd, c.key, "first", c.envelope)
+		first, err := cmd.getFileMetadata(c.key, testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		putTestFile(t, cmd, c.key, "second", c.envelope)
+		for j, x := range c.args {
+			if x == "first" {
+				c.args[j] = *first.VersionId
+			}
+		}
+
+		args := append([]string{"--bucket", testBucket}, c.args...)
+		_, err = runTestCommand(t, cmd, newRollbackCommand(cmd), rollbackFile, args...)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			if content := getTestFile(t, cmd, c.key); content != "second" {
+				t.Errorf("case %d: expected the file to be unchanged, got: %q", i, content)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if content := getTestFile(t, cmd, c.key); content != "first" {
+			t.Errorf("case %d: expected the first version to be restored, got: %q", i, content)
+		}
+	}
+}
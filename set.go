@@ -0,0 +1,132 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/urfave/cli"
+)
+
+//
+// newSetCommand creates a new set command
+//
+func newSetCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "set",
+		Usage:     "updates one or more fields of a json or yaml file in the bucket",
+		ArgsUsage: "KEY FIELD=VALUE...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.BoolFlag{
+				Name:  "typed",
+				Usage: "interpret the values as json, i.e. numbers, booleans, null, lists or objects, rather than strings",
+			},
+			cli.BoolFlag{
+				Name:  "force",
+				Usage: "update a yaml file even though the comments it contains will be lost",
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, setFields)
+		},
+	}
+}
+
+//
+// setFields updates the fields of a structured document in the bucket, keeping it's encryption
+//
+func setFields(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+
+	if len(cx.Args()) < 2 {
+		return fmt.Errorf("you must specify the file and at least one FIELD=VALUE to set")
+	}
+	key := cx.Args().First()
+
+	// step: parse the fields
+	var fields []documentField
+	for _, x := range cx.Args().Tail() {
+		items := strings.SplitN(x, "=", 2)
+		if len(items) != 2 || items[0] == "" {
+			return fmt.Errorf("invalid field: '%s', expected FIELD=VALUE", x)
+		}
+		var value interface{} = items[1]
+		if cx.Bool("typed") {
+			decoder := json.NewDecoder(strings.NewReader(items[1]))
+			decoder.UseNumber()
+			decoded, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return fmt.Errorf("the value of the field: %s is not valid json, error: %s", items[0], err)
+			}
+			value = decoded
+		}
+		fields = append(fields, documentField{name: items[0], value: value})
+	}
+
+	// step: retrieve the content, along with the etag and encryption of the version retrieved
+	content, resp, err := cmd.getFileRevision(bucket, key)
+	if err != nil {
+		return fmt.Errorf("unable to retrieve the file: %s, error: %s", key, err)
+	}
+	defer zeroBytes(content)
+	kmsID, envelope := retrievedEncryption(resp)
+	if kmsID == "" {
+		return fmt.Errorf("unable to determine the kms key used to encrypt the file: %s", key)
+	}
+
+	// step: update the content
+	// step: the comments of a yaml file are not retained, so refuse to drop them unless forced
+	if !cx.Bool("force") && documentFormat(key, content) == "yaml" && hasYAMLComments(content) {
+		return fmt.Errorf("the file: %s contains comments which would be lost, use --force to update it regardless", key)
+	}
+	updated, err := setDocumentFields(key, content, fields)
+	if err != nil {
+		return err
+	}
+	defer zeroBytes(updated)
+
+	// step: ensure the file has not been changed in the bucket since we retrieved it
+	if err := cmd.checkFileETag(bucket, key, aws.StringValue(resp.ETag)); err != nil {
+		return err
+	}
+
+	// step: upload the content to bucket
+	if err := cmd.uploadFile(bucket, key, bytes.NewReader(updated), kmsID, envelope); err != nil {
+		return err
+	}
+
+	var names []string
+	for _, x := range fields {
+		names = append(names, x.name)
+	}
+	o.fields(map[string]interface{}{
+		"action": "set",
+		"bucket": bucket,
+		"key":    key,
+		"fields": names,
+	}).log("successfully updated the fields: %s in the file: s3://%s/%s\n", strings.Join(names, ", "), bucket, key)
+
+	return nil
+}
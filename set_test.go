@@ -0,0 +1,143 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestSetFields(t *testing.T) {
+	cases := []struct {
+		key      string
+		content  string
+		envelope bool
+		flags    []string
+		fields   []string
+		expected string
+		error    bool
+	}{
+		{
+			key:      "config.json",
+			content:  `{"user": "app", "db": {"password": "old"}}`,
+			fields:   []string{"db.password=new"},
+			expected: `{"user":"app","db":{"password":"new"}}`,
+		},
+		{
+			key:      "config.json",
+			content:  "{\n  \"user\": \"app\"\n}\n",
+			envelope: true,
+			fields:   []string{"db.host=<host>", "user=admin"},
+			expected: "{\n  \"user\": \"admin\",\n  \"db\": {\n    \"host\": \"<host>\"\n  }\n}\n",
+		},
+		{
+			key:      "config.json",
+			content:  `{"port": "1", "id": 1}`,
+			flags:    []string{"--typed"},
+			fields:   []string{"port=5432", "id=12345678901234567890", "enabled=true"},
+			expected: `{"port":5432,"id":12345678901234567890,"enabled":true}`,
+		},
+		{
+			key:      "config.yaml",
+			content:  "user: app\ndb:\n  password: old\n",
+			fields:   []string{"db.password=new", "db.port=5432"},
+			expected: "user: app\ndb:\n  password: new\n  port: \"5432\"\n",
+		},
+		{
+			key:      "config.yaml",
+			content:  "user: app\n",
+			envelope: true,
+			flags:    []string{"--typed"},
+			fields:   []string{"port=5432", "hosts=[\"a\", \"b\"]"},
+			expected: "user: app\nport: 5432\nhosts:\n- a\n- b\n",
+		},
+		{
+			key:      "config.yaml",
+			content:  "# the user\nuser: app\n",
+			flags:    []string{"--force"},
+			fields:   []string{"user=admin"},
+			expected: "user: admin\n",
+		},
+		{
+			key:     "config.yaml",
+			content: "# the user\nuser: app\n",
+			fields:  []string{"user=admin"},
+			error:   true,
+		},
+		{
+			key:     "config.json",
+			content: `{"user": "app"}`,
+			fields:  []string{"user.name=admin"},
+			error:   true,
+		},
+		{
+			key:     "config.json",
+			content: `{"user": "app"}`,
+			flags:   []string{"--typed"},
+			fields:  []string{"user=admin"},
+			error:   true,
+		},
+		{
+			key:     "config.json",
+			content: `{"user": "app"}`,
+			fields:  []string{"=admin"},
+			error:   true,
+		},
+		{
+			key:     "app.env",
+			content: "USER=app\n",
+			fields:  []string{"USER=admin"},
+			error:   true,
+		},
+		{
+			key:     "config.json",
+			content: `{"user": "app"}`,
+			error:   true,
+		},
+	}
+	for i, c := range cases {
+		cmd := newTestCommand(t)
+		putTestFile(t, cmd, c.key, c.content, c.envelope)
+
+		args := append(append([]string{"--bucket", testBucket}, c.flags...), c.key)
+		args = append(args, c.fields...)
+		_, err := runTestCommand(t, cmd, newSetCommand(cmd), setFields, args...)
+		content := getTestFile(t, cmd, c.key)
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			if content != c.content {
+				t.Errorf("case %d: expected the file to be unchanged, got: %q", i, content)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if content != c.expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, content)
+		}
+		// step: the encryption of the file must be retained
+		metadata, err := cmd.getFileMetadata(c.key, testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, envelope := objectEncryption(metadata); envelope != c.envelope {
+			t.Errorf("case %d: expected envelope: %t, got: %t", i, c.envelope, envelope)
+		}
+	}
+}
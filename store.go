@@ -0,0 +1,416 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// SecretStore is the storage backend holding the encrypted files
+type SecretStore interface {
+	// ListBuckets retrieves the buckets available
+	ListBuckets() ([]*s3.Bucket, error)
+	// CreateBucket creates a new bucket
+	CreateBucket(bucket string) error
+	// DeleteBucket removes an empty bucket
+	DeleteBucket(bucket string) error
+	// List calls the method for each object under the prefix, the delimiter groups any keys below it
+	List(bucket, prefix, delimiter string, method func(*s3.Object) error) error
+	// ListVersions calls the method for each version of the objects under the prefix, newest first
+	ListVersions(bucket, prefix string, method func(*s3.ObjectVersion) error) error
+	// Head retrieves the metadata for an object, an empty version is the current version
+	Head(bucket, key, versionID string) (*s3.HeadObjectOutput, error)
+	// Get retrieves an object, it's the callers responsibility to close the body
+	Get(bucket, key, versionID string) (*s3.GetObjectOutput, error)
+	// Download retrieves the content of an object into the writer, returning the number of bytes, the
+	// download fails if the etag is given and the object no longer matches it
+	Download(bucket, key, versionID, etag string, w io.WriterAt) (int64, error)
+	// Put uploads an object
+	Put(input *s3manager.UploadInput) error
+	// Copy performs a server side copy of an object
+	Copy(input *s3.CopyObjectInput) error
+	// Delete removes an object
+	Delete(bucket, key string) error
+}
+
+// awsStore is the s3 implementation of the secret store, the requests for a bucket are made to the region
+// the bucket resides in
+type awsStore struct {
+	sync.Mutex
+	// the config the clients are created from
+	config client.ConfigProvider
+	// the clients for the default region
+	defaults *awsRegionClients
+	// the clients for each region, created as required
+	regions map[string]*awsRegionClients
+	// the region of each bucket, nil if we are not detecting the regions
+	buckets map[string]string
+}
+
+// awsRegionClients are the s3 clients for a region
+type awsRegionClients struct {
+	// the s3 client
+	client *s3.S3
+	// the s3 uploader
+	uploader *s3manager.Uploader
+	// the s3 downloader, retrieving objects in concurrent ranged parts
+	downloader *s3manager.Downloader
+}
+
+//
+// newAWSStore creates a s3 backed secret store, when detecting the regions the location of each bucket is
+// retrieved on first use, else all the requests are made to the region in the config
+//
+func newAWSStore(config client.ConfigProvider, detectRegions bool) SecretStore {
+	store := &awsStore{
+		config:   config,
+		defaults: newAWSRegionClients(s3.New(config)),
+		regions:  make(map[string]*awsRegionClients, 0),
+	}
+	if detectRegions {
+		store.buckets = make(map[string]string, 0)
+	}
+
+	return store
+}
+
+//
+// newAWSRegionClients creates the uploader and downloader for the client
+//
+func newAWSRegionClients(client *s3.S3) *awsRegionClients {
+	return &awsRegionClients{
+		client:     client,
+		uploader:   s3manager.NewUploaderWithClient(client),
+		downloader: s3manager.NewDownloaderWithClient(client),
+	}
+}
+
+func (r *awsStore) ListBuckets() ([]*s3.Bucket, error) {
+	resp, err := r.defaults.client.ListBuckets(&s3.ListBucketsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Buckets, nil
+}
+
+func (r *awsStore) CreateBucket(bucket string) error {
+	_, err := r.defaults.client.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	})
+
+	return err
+}
+
+func (r *awsStore) DeleteBucket(bucket string) error {
+	_, err := r.clients(bucket).client.DeleteBucket(&s3.DeleteBucketInput{
+		Bucket: aws.String(bucket),
+	})
+	if err == nil {
+		r.forget(bucket)
+	}
+
+	return err
+}
+
+func (r *awsStore) List(bucket, prefix, delimiter string, method func(*s3.Object) error) error {
+	var walkErr error
+
+	input := &s3.ListObjectsV2Input{
+		Bucket:     aws.String(bucket),
+		Prefix:     aws.String(prefix),
+		FetchOwner: aws.Bool(true),
+	}
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+
+	err := r.clients(bucket).client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, x := range page.Contents {
+			if walkErr = method(x); walkErr != nil {
+				return false
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return walkErr
+}
+
+func (r *awsStore) ListVersions(bucket, prefix string, method func(*s3.ObjectVersion) error) error {
+	var walkErr error
+
+	err := r.clients(bucket).client.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, x := range page.Versions {
+			if walkErr = method(x); walkErr != nil {
+				return false
+			}
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	return walkErr
+}
+
+func (r *awsStore) Head(bucket, key, versionID string) (*s3.HeadObjectOutput, error) {
+	return r.clients(bucket).client.HeadObject(&s3.HeadObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: optionalString(versionID),
+	})
+}
+
+func (r *awsStore) Get(bucket, key, versionID string) (*s3.GetObjectOutput, error) {
+	return r.clients(bucket).client.GetObject(&s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: optionalString(versionID),
+	})
+}
+
+func (r *awsStore) Download(bucket, key, versionID, etag string, w io.WriterAt) (int64, error) {
+	return r.clients(bucket).downloader.Download(w, &s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: optionalString(versionID),
+		IfMatch:   optionalString(etag),
+	})
+}
+
+func (r *awsStore) Put(input *s3manager.UploadInput) error {
+	_, err := r.clients(aws.StringValue(input.Bucket)).uploader.Upload(input)
+
+	return err
+}
+
+func (r *awsStore) Copy(input *s3.CopyObjectInput) error {
+	_, err := r.clients(aws.StringValue(input.Bucket)).client.CopyObject(input)
+
+	return err
+}
+
+func (r *awsStore) Delete(bucket, key string) error {
+	_, err := r.clients(bucket).client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+
+	return err
+}
+
+//
+// clients returns the clients for the region of the bucket, creating them if required
+//
+func (r *awsStore) clients(bucket string) *awsRegionClients {
+	if r.buckets == nil {
+		return r.defaults
+	}
+	region := r.bucketRegion(bucket)
+
+	r.Lock()
+	defer r.Unlock()
+	if region == "" || region == aws.StringValue(r.defaults.client.Config.Region) {
+		return r.defaults
+	}
+	clients, found := r.regions[region]
+	if !found {
+		clients = newAWSRegionClients(s3.New(r.config, &aws.Config{Region: aws.String(region)}))
+		r.regions[region] = clients
+	}
+
+	return clients
+}
+
+//
+// bucketRegion returns the region of the bucket, retrieving it's location on first use. If the location
+// is not permitted we fall back to the region s3 returns in the headers of a head request, and failing
+// that the default region; whichever is chosen is cached so the lookup is only made once per bucket
+//
+func (r *awsStore) bucketRegion(bucket string) string {
+	r.Lock()
+	region, found := r.buckets[bucket]
+	r.Unlock()
+	if found {
+		return region
+	}
+
+	if resp, err := r.defaults.client.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(bucket)}); err == nil {
+		region = normalizeBucketLocation(aws.StringValue(resp.LocationConstraint))
+	} else {
+		// step: s3 returns the region header on the redirect and access denied errors as well
+		req, _ := r.defaults.client.HeadBucketRequest(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+		headErr := req.Send()
+		if req.HTTPResponse != nil {
+			region = req.HTTPResponse.Header.Get("X-Amz-Bucket-Region")
+		}
+		if region == "" {
+			region = aws.StringValue(r.defaults.client.Config.Region)
+			if headErr == nil {
+				headErr = err
+			}
+			fmt.Fprintf(os.Stderr, "[warn] unable to determine the region of the bucket: %s, using the region: %s, error: %s\n",
+				bucket, region, headErr)
+		}
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.buckets[bucket] = region
+
+	return region
+}
+
+//
+// forget removes the cached region of the bucket
+//
+func (r *awsStore) forget(bucket string) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.buckets, bucket)
+}
+
+//
+// normalizeBucketLocation converts the location constraint of a bucket to the region, the constraint is
+// empty for us-east-1 and may be EU for the older buckets in eu-west-1
+//
+func normalizeBucketLocation(location string) string {
+	switch location {
+	case "":
+		return "us-east-1"
+	case "EU":
+		return "eu-west-1"
+	}
+
+	return location
+}
+
+//
+// filterKeys sorts and filters the keys by the prefix, when a delimiter is given any keys which contain
+// the delimiter post the prefix are removed, mimicking the s3 listing
+//
+func filterKeys(keys []string, prefix, delimiter string) []string {
+	var list []string
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if delimiter != "" && strings.Contains(strings.TrimPrefix(k, prefix), delimiter) {
+			continue
+		}
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	return list
+}
+
+//
+// errNoSuchBucket is returned by the fake stores when the bucket does not exist
+//
+func errNoSuchBucket(bucket string) error {
+	return awserr.New("NoSuchBucket", "the specified bucket does not exist: "+bucket, nil)
+}
+
+//
+// copySource returns the url encoded source of a copy request
+//
+func copySource(bucket, key, versionID string) string {
+	source := escapePath(bucket + "/" + key)
+	if versionID != "" {
+		source += "?versionId=" + url.QueryEscape(versionID)
+	}
+
+	return source
+}
+
+//
+// escapePath percent encodes each segment of the path, everything but the unreserved characters and the
+// separators are escaped, i.e. a space is %20 and a plus %2B
+//
+func escapePath(path string) string {
+	b := new(bytes.Buffer)
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+			b.WriteByte(c)
+		case c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+//
+// errNoSuchVersion is returned by the fake stores when the version of the key does not exist
+//
+func errNoSuchVersion(key, versionID string) error {
+	return awserr.New("NoSuchVersion", fmt.Sprintf("the version: %s of the key: %s does not exist", versionID, key), nil)
+}
+
+//
+// errPreconditionFailed is returned by the fake stores when the object does not match the etag
+//
+func errPreconditionFailed(key string) error {
+	return awserr.New("PreconditionFailed", "the key: "+key+" does not match the etag", nil)
+}
+
+//
+// errNoSuchKey is returned by the fake stores when the key does not exist
+//
+func errNoSuchKey(key string) error {
+	return awserr.New("NoSuchKey", "the specified key does not exist: "+key, nil)
+}
+
+//
+// isNoSuchKey checks if the error is due to the key not existing
+//
+func isNoSuchKey(err error) bool {
+	if e, ok := err.(awserr.Error); ok {
+		// step: a head request has no body, so s3 reports the missing key as not found
+		return e.Code() == "NoSuchKey" || e.Code() == "NotFound"
+	}
+
+	return false
+}
@@ -0,0 +1,595 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+const (
+	// localMetadataDir is the directory under the root holding the object metadata
+	localMetadataDir = ".metadata"
+	// localKeysDir is the directory under the root holding the keyring
+	localKeysDir = ".keys"
+	// localVersionsDir is the directory under the root holding the previous versions of the objects
+	localVersionsDir = ".versions"
+	// localSealOverhead is the size the nonce and tag of a sealed object add to the content
+	localSealOverhead = 12 + 16
+)
+
+// localStore is a directory backed implementation of the secret store, each bucket is a directory under
+// the root and the objects are files within them. Previous versions of the objects are retained under
+// the versions directory. Server side encryption is emulated by sealing the content with a data key from
+// the key service, the wrapped key is held in the metadata of the object.
+type localStore struct {
+	// the root directory of the store
+	root string
+	// the key service used to encrypt the content at rest
+	keyService KeyService
+}
+
+//
+// newLocalStore creates a directory backed secret store, encrypting the content with the key service
+//
+func newLocalStore(root string, keyService KeyService) (SecretStore, error) {
+	if err := os.MkdirAll(filepath.Join(root, localMetadataDir), 0700); err != nil {
+		return nil, err
+	}
+
+	return &localStore{root: root, keyService: keyService}, nil
+}
+
+func (r *localStore) ListBuckets() ([]*s3.Bucket, error) {
+	files, err := ioutil.ReadDir(r.root)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*s3.Bucket
+	for _, x := range files {
+		if !x.IsDir() || strings.HasPrefix(x.Name(), ".") {
+			continue
+		}
+		list = append(list, &s3.Bucket{
+			Name:         aws.String(x.Name()),
+			CreationDate: aws.Time(x.ModTime().UTC()),
+		})
+	}
+
+	return list, nil
+}
+
+func (r *localStore) CreateBucket(bucket string) error {
+	path, err := r.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("the bucket: %s already exists", bucket)
+	}
+
+	return os.MkdirAll(path, 0700)
+}
+
+func (r *localStore) DeleteBucket(bucket string) error {
+	path, err := r.bucketPath(bucket)
+	if err != nil {
+		return err
+	}
+	keys, err := r.keys(bucket)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return fmt.Errorf("the bucket: %s is not empty", bucket)
+	}
+	os.RemoveAll(filepath.Join(r.root, localMetadataDir, bucket))
+	os.RemoveAll(filepath.Join(r.root, localVersionsDir, bucket))
+
+	return os.RemoveAll(path)
+}
+
+func (r *localStore) List(bucket, prefix, delimiter string, method func(*s3.Object) error) error {
+	keys, err := r.keys(bucket)
+	if err != nil {
+		return err
+	}
+	for _, k := range filterKeys(keys, prefix, delimiter) {
+		object, err := r.load(bucket, k, "", false)
+		if err != nil {
+			return err
+		}
+		if err := method(object.object(k)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *localStore) ListVersions(bucket, prefix string, method func(*s3.ObjectVersion) error) error {
+	keys, err := r.keys(bucket)
+	if err != nil {
+		return err
+	}
+	archived, err := r.archivedKeys(bucket)
+	if err != nil {
+		return err
+	}
+	current := make(map[string]bool, 0)
+	for _, k := range keys {
+		current[k] = true
+	}
+	for _, k := range archived {
+		if !current[k] {
+			keys = append(keys, k)
+		}
+	}
+
+	for _, k := range filterKeys(keys, prefix, "") {
+		if current[k] {
+			object, err := r.load(bucket, k, "", false)
+			if err != nil {
+				return err
+			}
+			if err := method(object.version(k, true)); err != nil {
+				return err
+			}
+		}
+		versions, err := r.versions(bucket, k)
+		if err != nil {
+			return err
+		}
+		for _, x := range versions {
+			if err := method(x.version(k, false)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *localStore) Head(bucket, key, versionID string) (*s3.HeadObjectOutput, error) {
+	object, err := r.load(bucket, key, versionID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return object.head(), nil
+}
+
+func (r *localStore) Get(bucket, key, versionID string) (*s3.GetObjectOutput, error) {
+	object, err := r.load(bucket, key, versionID, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return object.get(), nil
+}
+
+func (r *localStore) Download(bucket, key, versionID, etag string, w io.WriterAt) (int64, error) {
+	object, err := r.load(bucket, key, versionID, true)
+	if err != nil {
+		return 0, err
+	}
+	if etag != "" && object.ETag != etag {
+		return 0, errPreconditionFailed(key)
+	}
+	n, err := w.WriteAt(object.Content, 0)
+
+	return int64(n), err
+}
+
+func (r *localStore) Put(input *s3manager.UploadInput) error {
+	object, err := newStoredObject(input)
+	if err != nil {
+		return err
+	}
+
+	return r.store(aws.StringValue(input.Bucket), aws.StringValue(input.Key), object)
+}
+
+func (r *localStore) Copy(input *s3.CopyObjectInput) error {
+	bucket, key, versionID, err := parseCopySource(aws.StringValue(input.CopySource))
+	if err != nil {
+		return err
+	}
+	source, err := r.load(bucket, key, versionID, true)
+	if err != nil {
+		return err
+	}
+	if etag := aws.StringValue(input.CopySourceIfMatch); etag != "" && source.ETag != etag {
+		return errPreconditionFailed(key)
+	}
+
+	return r.store(aws.StringValue(input.Bucket), aws.StringValue(input.Key), copyStoredObject(source, input))
+}
+
+func (r *localStore) Delete(bucket, key string) error {
+	if _, err := r.keys(bucket); err != nil {
+		return err
+	}
+
+	// step: the current version is retained as a previous version
+	return r.archive(bucket, key)
+}
+
+//
+// store writes the object as the current version of the key, archiving any existing version
+//
+func (r *localStore) store(bucket, key string, object *storedObject) error {
+	if _, err := r.keys(bucket); err != nil {
+		return err
+	}
+	path, metaPath, err := r.objectPaths(bucket, key)
+	if err != nil {
+		return err
+	}
+	content, err := r.seal(object)
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	if err := r.archive(bucket, key); err != nil {
+		return err
+	}
+
+	// step: write the content and metadata of the object
+	for _, x := range []string{path, metaPath} {
+		if err := os.MkdirAll(filepath.Dir(x), 0700); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(metaPath, encoded, 0600)
+}
+
+//
+// archive moves the current version of the key, if any, into the versions directory
+//
+func (r *localStore) archive(bucket, key string) error {
+	object, err := r.load(bucket, key, "", false)
+	if err != nil {
+		if isNoSuchKey(err) {
+			return nil
+		}
+		return err
+	}
+	path, metaPath, err := r.objectPaths(bucket, key)
+	if err != nil {
+		return err
+	}
+	versionPath, err := r.versionPath(bucket, key)
+	if err != nil {
+		return err
+	}
+	// step: files copied into the directory will not have a version
+	if object.VersionID == "" {
+		object.VersionID = newVersionID()
+	}
+	encoded, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(versionPath, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(versionPath, object.VersionID+".json"), encoded, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(path, filepath.Join(versionPath, object.VersionID)); err != nil {
+		return err
+	}
+	os.Remove(metaPath)
+
+	return nil
+}
+
+//
+// versions retrieves the previous versions of the key, newest first
+//
+func (r *localStore) versions(bucket, key string) ([]*storedObject, error) {
+	versionPath, err := r.versionPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(versionPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var list []*storedObject
+	for _, x := range files {
+		if x.IsDir() || !strings.HasSuffix(x.Name(), ".json") {
+			continue
+		}
+		object, err := r.loadVersion(bucket, key, strings.TrimSuffix(x.Name(), ".json"), false)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, object)
+	}
+	sort.Sort(sort.Reverse(byModified(list)))
+
+	return list, nil
+}
+
+//
+// archivedKeys retrieves all the keys within the bucket which have previous versions
+//
+func (r *localStore) archivedKeys(bucket string) ([]string, error) {
+	path := filepath.Join(r.root, localVersionsDir, bucket)
+	if found, err := isDirectory(path); err != nil || !found {
+		return nil, nil
+	}
+
+	keys := make(map[string]bool, 0)
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && strings.HasSuffix(p, ".json") {
+			keys[filepath.ToSlash(strings.TrimPrefix(filepath.Dir(p), path+string(os.PathSeparator)))] = true
+		}
+		return nil
+	})
+
+	var list []string
+	for k := range keys {
+		list = append(list, k)
+	}
+
+	return list, err
+}
+
+//
+// keys retrieves all the keys within the bucket
+//
+func (r *localStore) keys(bucket string) ([]string, error) {
+	path, err := r.bucketPath(bucket)
+	if err != nil {
+		return nil, err
+	}
+	if found, err := isDirectory(path); err != nil || !found {
+		return nil, errNoSuchBucket(bucket)
+	}
+
+	var list []string
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			list = append(list, filepath.ToSlash(strings.TrimPrefix(p, path+string(os.PathSeparator))))
+		}
+		return nil
+	})
+
+	return list, err
+}
+
+//
+// load reads the object and optionally it's content from disk, an empty version is the current version
+//
+func (r *localStore) load(bucket, key, versionID string, content bool) (*storedObject, error) {
+	path, metaPath, err := r.objectPaths(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err != nil && versionID == "" {
+		return nil, errNoSuchKey(key)
+	}
+	if err != nil {
+		return r.loadVersion(bucket, key, versionID, content)
+	}
+
+	object := &storedObject{Modified: info.ModTime().UTC(), Size: info.Size()}
+	// step: the metadata is optional, i.e. files could have been copied into the directory
+	if encoded, err := ioutil.ReadFile(metaPath); err == nil {
+		if err := json.Unmarshal(encoded, object); err != nil {
+			return nil, fmt.Errorf("invalid metadata for key: %s, error: %s", key, err)
+		}
+	}
+	if versionID != "" && object.VersionID != versionID {
+		return r.loadVersion(bucket, key, versionID, content)
+	}
+	if content {
+		if object.Content, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return object, r.open(object, key)
+}
+
+//
+// loadVersion reads a previous version of the object from the versions directory
+//
+func (r *localStore) loadVersion(bucket, key, versionID string, content bool) (*storedObject, error) {
+	versionPath, err := r.versionPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(versionID, `/\.`) {
+		return nil, errNoSuchVersion(key, versionID)
+	}
+	encoded, err := ioutil.ReadFile(filepath.Join(versionPath, versionID+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errNoSuchVersion(key, versionID)
+		}
+		return nil, err
+	}
+	info, err := os.Stat(filepath.Join(versionPath, versionID))
+	if err != nil {
+		return nil, err
+	}
+	object := &storedObject{Size: info.Size()}
+	if err := json.Unmarshal(encoded, object); err != nil {
+		return nil, fmt.Errorf("invalid metadata for key: %s, error: %s", key, err)
+	}
+	if content {
+		if object.Content, err = ioutil.ReadFile(filepath.Join(versionPath, versionID)); err != nil {
+			return nil, err
+		}
+	}
+
+	return object, r.open(object, key)
+}
+
+//
+// seal returns the content of the object as written to disk, server side encrypted objects are sealed with
+// a data key generated from the kms key of the object
+//
+func (r *localStore) seal(object *storedObject) ([]byte, error) {
+	object.SealedKey = nil
+	if object.Encryption != s3.ServerSideEncryptionAwsKms {
+		return object.Content, nil
+	}
+	plaintext, wrapped, _, err := r.keyService.GenerateDataKey(object.KMSKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate a data key, error: %s", err)
+	}
+	defer zeroBytes(plaintext)
+
+	gcm, err := newEnvelopeCipher(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	// step: the sealed content is nonce | ciphertext, and as with kms the etag is not the digest of the plaintext
+	sealed := gcm.Seal(nonce, nonce, object.Content, nil)
+	checksum := md5.Sum(sealed)
+	object.ETag = fmt.Sprintf("\"%s\"", hex.EncodeToString(checksum[:]))
+	object.SealedKey = wrapped
+
+	return sealed, nil
+}
+
+//
+// open decrypts the content of a sealed object read from disk, the size is always that of the plaintext
+//
+func (r *localStore) open(object *storedObject, key string) error {
+	if len(object.SealedKey) == 0 {
+		return nil
+	}
+	object.Size -= localSealOverhead
+	if object.Content == nil {
+		return nil
+	}
+	plaintext, _, err := r.keyService.Decrypt(object.KMSKeyID, object.SealedKey)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt the data key for key: %s, error: %s", key, err)
+	}
+	defer zeroBytes(plaintext)
+
+	gcm, err := newEnvelopeCipher(plaintext)
+	if err != nil {
+		return err
+	}
+	if len(object.Content) < gcm.NonceSize() {
+		return fmt.Errorf("invalid sealed content for key: %s", key)
+	}
+	content, err := gcm.Open(nil, object.Content[:gcm.NonceSize()], object.Content[gcm.NonceSize():], nil)
+	if err != nil {
+		return fmt.Errorf("unable to decrypt the content of key: %s, error: %s", key, err)
+	}
+	object.Content = content
+
+	return nil
+}
+
+//
+// bucketPath returns the directory for the bucket
+//
+func (r *localStore) bucketPath(bucket string) (string, error) {
+	if bucket == "" || strings.HasPrefix(bucket, ".") || strings.ContainsAny(bucket, `/\`) {
+		return "", fmt.Errorf("invalid bucket name: '%s'", bucket)
+	}
+
+	return filepath.Join(r.root, bucket), nil
+}
+
+//
+// objectPaths returns the path of the content and metadata for an object, ensuring the key
+// cannot escape the bucket
+//
+func (r *localStore) objectPaths(bucket, key string) (string, string, error) {
+	path, err := r.bucketPath(bucket)
+	if err != nil {
+		return "", "", err
+	}
+	name := filepath.Clean(filepath.FromSlash(key))
+	if key == "" || name == "." || filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(os.PathSeparator)) {
+		return "", "", fmt.Errorf("invalid key: '%s'", key)
+	}
+
+	return filepath.Join(path, name), filepath.Join(r.root, localMetadataDir, bucket, name), nil
+}
+
+//
+// versionPath returns the directory holding the previous versions of an object
+//
+func (r *localStore) versionPath(bucket, key string) (string, error) {
+	path, _, err := r.objectPaths(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	name, err := filepath.Rel(filepath.Join(r.root, bucket), path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(r.root, localVersionsDir, bucket, name), nil
+}
+
+// byModified sorts the objects by their modification time
+type byModified []*storedObject
+
+func (r byModified) Len() int           { return len(r) }
+func (r byModified) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byModified) Less(i, j int) bool { return r[i].Modified.Before(r[j].Modified) }
@@ -0,0 +1,454 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// storedObject is an object held by one of the fake stores
+type storedObject struct {
+	// the content of the object
+	Content []byte `json:"-"`
+	// the size of the content
+	Size int64 `json:"-"`
+	// the version of the object
+	VersionID string `json:"version-id"`
+	// the user metadata for the object
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// the server side encryption used
+	Encryption string `json:"encryption,omitempty"`
+	// the kms key used for server side encryption
+	KMSKeyID string `json:"kms-key-id,omitempty"`
+	// the wrapped data key the content is sealed with at rest, only used by the local store
+	SealedKey []byte `json:"sealed-key,omitempty"`
+	// the etag of the content
+	ETag string `json:"etag"`
+	// the time the object was last modified
+	Modified time.Time `json:"modified"`
+}
+
+//
+// newStoredObject creates a stored object from the upload
+//
+func newStoredObject(input *s3manager.UploadInput) (*storedObject, error) {
+	content, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	checksum := md5.Sum(content)
+
+	return &storedObject{
+		Content:    content,
+		Size:       int64(len(content)),
+		VersionID:  newVersionID(),
+		Metadata:   aws.StringValueMap(input.Metadata),
+		Encryption: aws.StringValue(input.ServerSideEncryption),
+		KMSKeyID:   aws.StringValue(input.SSEKMSKeyId),
+		ETag:       fmt.Sprintf("\"%s\"", hex.EncodeToString(checksum[:])),
+		Modified:   time.Now().UTC(),
+	}, nil
+}
+
+//
+// copyStoredObject creates a new version of the object from the copy request
+//
+func copyStoredObject(source *storedObject, input *s3.CopyObjectInput) *storedObject {
+	object := &storedObject{
+		Content:    source.Content,
+		Size:       source.Size,
+		VersionID:  newVersionID(),
+		Metadata:   source.Metadata,
+		Encryption: aws.StringValue(input.ServerSideEncryption),
+		KMSKeyID:   aws.StringValue(input.SSEKMSKeyId),
+		ETag:       source.ETag,
+		Modified:   time.Now().UTC(),
+	}
+	if aws.StringValue(input.MetadataDirective) == s3.MetadataDirectiveReplace {
+		object.Metadata = aws.StringValueMap(input.Metadata)
+	}
+
+	return object
+}
+
+func (r *storedObject) object(key string) *s3.Object {
+	return &s3.Object{
+		Key:          aws.String(key),
+		ETag:         aws.String(r.ETag),
+		Size:         aws.Int64(r.Size),
+		LastModified: aws.Time(r.Modified),
+		StorageClass: aws.String(s3.ObjectStorageClassStandard),
+		Owner:        &s3.Owner{DisplayName: aws.String(progName), ID: aws.String(progName)},
+	}
+}
+
+func (r *storedObject) version(key string, latest bool) *s3.ObjectVersion {
+	return &s3.ObjectVersion{
+		Key:          aws.String(key),
+		VersionId:    aws.String(r.VersionID),
+		IsLatest:     aws.Bool(latest),
+		ETag:         aws.String(r.ETag),
+		Size:         aws.Int64(r.Size),
+		LastModified: aws.Time(r.Modified),
+		StorageClass: aws.String(s3.ObjectStorageClassStandard),
+		Owner:        &s3.Owner{DisplayName: aws.String(progName), ID: aws.String(progName)},
+	}
+}
+
+func (r *storedObject) head() *s3.HeadObjectOutput {
+	return &s3.HeadObjectOutput{
+		ContentLength:        aws.Int64(r.Size),
+		ETag:                 aws.String(r.ETag),
+		LastModified:         aws.Time(r.Modified),
+		Metadata:             aws.StringMap(r.Metadata),
+		ServerSideEncryption: optionalString(r.Encryption),
+		SSEKMSKeyId:          optionalString(r.KMSKeyID),
+		VersionId:            optionalString(r.VersionID),
+	}
+}
+
+func (r *storedObject) get() *s3.GetObjectOutput {
+	return &s3.GetObjectOutput{
+		Body:                 ioutil.NopCloser(bytes.NewReader(r.Content)),
+		ContentLength:        aws.Int64(r.Size),
+		ETag:                 aws.String(r.ETag),
+		LastModified:         aws.Time(r.Modified),
+		Metadata:             aws.StringMap(r.Metadata),
+		ServerSideEncryption: optionalString(r.Encryption),
+		SSEKMSKeyId:          optionalString(r.KMSKeyID),
+		VersionId:            optionalString(r.VersionID),
+	}
+}
+
+// memoryBucket is a bucket in the in-memory store
+type memoryBucket struct {
+	// the creation time of the bucket
+	created time.Time
+	// the current version of the objects
+	objects map[string]*storedObject
+	// the previous versions of the objects, oldest first
+	versions map[string][]*storedObject
+}
+
+// memoryStore is a in-memory implementation of the secret store, versioning is always enabled
+type memoryStore struct {
+	sync.RWMutex
+	// the buckets and their objects
+	buckets map[string]*memoryBucket
+}
+
+//
+// newMemoryStore creates an empty in-memory secret store
+//
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		buckets: make(map[string]*memoryBucket, 0),
+	}
+}
+
+func (r *memoryStore) ListBuckets() ([]*s3.Bucket, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	var names []string
+	for name := range r.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var list []*s3.Bucket
+	for _, name := range names {
+		list = append(list, &s3.Bucket{
+			Name:         aws.String(name),
+			CreationDate: aws.Time(r.buckets[name].created),
+		})
+	}
+
+	return list, nil
+}
+
+func (r *memoryStore) CreateBucket(bucket string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, found := r.buckets[bucket]; found {
+		return fmt.Errorf("the bucket: %s already exists", bucket)
+	}
+	r.buckets[bucket] = &memoryBucket{
+		created:  time.Now().UTC(),
+		objects:  make(map[string]*storedObject, 0),
+		versions: make(map[string][]*storedObject, 0),
+	}
+
+	return nil
+}
+
+func (r *memoryStore) DeleteBucket(bucket string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	b, found := r.buckets[bucket]
+	if !found {
+		return errNoSuchBucket(bucket)
+	}
+	if len(b.objects) > 0 {
+		return fmt.Errorf("the bucket: %s is not empty", bucket)
+	}
+	delete(r.buckets, bucket)
+
+	return nil
+}
+
+func (r *memoryStore) List(bucket, prefix, delimiter string, method func(*s3.Object) error) error {
+	// step: take a copy of the objects so the method is free to modify the store
+	r.RLock()
+	b, found := r.buckets[bucket]
+	if !found {
+		r.RUnlock()
+		return errNoSuchBucket(bucket)
+	}
+	var keys []string
+	for k := range b.objects {
+		keys = append(keys, k)
+	}
+	var list []*s3.Object
+	for _, k := range filterKeys(keys, prefix, delimiter) {
+		list = append(list, b.objects[k].object(k))
+	}
+	r.RUnlock()
+
+	for _, x := range list {
+		if err := method(x); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryStore) ListVersions(bucket, prefix string, method func(*s3.ObjectVersion) error) error {
+	r.RLock()
+	b, found := r.buckets[bucket]
+	if !found {
+		r.RUnlock()
+		return errNoSuchBucket(bucket)
+	}
+	keys := make(map[string]bool, 0)
+	for k := range b.objects {
+		keys[k] = true
+	}
+	for k := range b.versions {
+		keys[k] = true
+	}
+	var names []string
+	for k := range keys {
+		names = append(names, k)
+	}
+	// step: the versions are returned newest first, as s3 does
+	var list []*s3.ObjectVersion
+	for _, k := range filterKeys(names, prefix, "") {
+		if object, found := b.objects[k]; found {
+			list = append(list, object.version(k, true))
+		}
+		for i := len(b.versions[k]) - 1; i >= 0; i-- {
+			list = append(list, b.versions[k][i].version(k, false))
+		}
+	}
+	r.RUnlock()
+
+	for _, x := range list {
+		if err := method(x); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *memoryStore) Head(bucket, key, versionID string) (*s3.HeadObjectOutput, error) {
+	object, err := r.lookup(bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return object.head(), nil
+}
+
+func (r *memoryStore) Get(bucket, key, versionID string) (*s3.GetObjectOutput, error) {
+	object, err := r.lookup(bucket, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return object.get(), nil
+}
+
+func (r *memoryStore) Download(bucket, key, versionID, etag string, w io.WriterAt) (int64, error) {
+	object, err := r.lookup(bucket, key, versionID)
+	if err != nil {
+		return 0, err
+	}
+	if etag != "" && object.ETag != etag {
+		return 0, errPreconditionFailed(key)
+	}
+	n, err := w.WriteAt(object.Content, 0)
+
+	return int64(n), err
+}
+
+func (r *memoryStore) Put(input *s3manager.UploadInput) error {
+	object, err := newStoredObject(input)
+	if err != nil {
+		return err
+	}
+
+	return r.store(aws.StringValue(input.Bucket), aws.StringValue(input.Key), object)
+}
+
+func (r *memoryStore) Copy(input *s3.CopyObjectInput) error {
+	bucket, key, versionID, err := parseCopySource(aws.StringValue(input.CopySource))
+	if err != nil {
+		return err
+	}
+	source, err := r.lookup(bucket, key, versionID)
+	if err != nil {
+		return err
+	}
+	if etag := aws.StringValue(input.CopySourceIfMatch); etag != "" && source.ETag != etag {
+		return errPreconditionFailed(key)
+	}
+
+	return r.store(aws.StringValue(input.Bucket), aws.StringValue(input.Key), copyStoredObject(source, input))
+}
+
+func (r *memoryStore) Delete(bucket, key string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	b, found := r.buckets[bucket]
+	if !found {
+		return errNoSuchBucket(bucket)
+	}
+	// step: the current version is retained as a previous version
+	if object, found := b.objects[key]; found {
+		b.versions[key] = append(b.versions[key], object)
+		delete(b.objects, key)
+	}
+
+	return nil
+}
+
+//
+// store adds the object as the current version of the key
+//
+func (r *memoryStore) store(bucket, key string, object *storedObject) error {
+	r.Lock()
+	defer r.Unlock()
+
+	b, found := r.buckets[bucket]
+	if !found {
+		return errNoSuchBucket(bucket)
+	}
+	if current, found := b.objects[key]; found {
+		b.versions[key] = append(b.versions[key], current)
+	}
+	b.objects[key] = object
+
+	return nil
+}
+
+//
+// lookup finds the object in the store, an empty version is the current version
+//
+func (r *memoryStore) lookup(bucket, key, versionID string) (*storedObject, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	b, found := r.buckets[bucket]
+	if !found {
+		return nil, errNoSuchBucket(bucket)
+	}
+	object, found := b.objects[key]
+	if versionID == "" {
+		if !found {
+			return nil, errNoSuchKey(key)
+		}
+		return object, nil
+	}
+	if found && object.VersionID == versionID {
+		return object, nil
+	}
+	for _, x := range b.versions[key] {
+		if x.VersionID == versionID {
+			return x, nil
+		}
+	}
+
+	return nil, errNoSuchVersion(key, versionID)
+}
+
+//
+// parseCopySource extracts the bucket, key and version from the copy source
+//
+func parseCopySource(source string) (string, string, string, error) {
+	location, err := url.Parse(source)
+	if err != nil {
+		return "", "", "", err
+	}
+	items := strings.SplitN(strings.TrimPrefix(location.Path, "/"), "/", 2)
+	if len(items) != 2 {
+		return "", "", "", fmt.Errorf("invalid copy source: %s", source)
+	}
+
+	return items[0], items[1], location.Query().Get("versionId"), nil
+}
+
+//
+// newVersionID generates a random version id for an object
+//
+func newVersionID() string {
+	b, err := randomBytes(16)
+	if err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+//
+// optionalString returns a nil for an empty string
+//
+func optionalString(v string) *string {
+	if v == "" {
+		return nil
+	}
+
+	return aws.String(v)
+}
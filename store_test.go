@@ -0,0 +1,227 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestCopySource(t *testing.T) {
+	cases := []struct {
+		bucket, key, versionID string
+		expected               string
+	}{
+		{bucket: "b1", key: "a.txt", expected: "b1/a.txt"},
+		{bucket: "b1", key: "dir/a.txt", versionID: "v1", expected: "b1/dir/a.txt?versionId=v1"},
+		{bucket: "b1", key: "dir/a b+c.txt", expected: "b1/dir/a%20b%2Bc.txt"},
+		{bucket: "b1", key: "a?b#c%d", versionID: "v+1", expected: "b1/a%3Fb%23c%25d?versionId=v%2B1"},
+	}
+	for i, c := range cases {
+		source := copySource(c.bucket, c.key, c.versionID)
+		if source != c.expected {
+			t.Errorf("case %d: expected: %s, got: %s", i, c.expected, source)
+		}
+		// step: the fake stores must parse the source back into the same object
+		bucket, key, versionID, err := parseCopySource(source)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if bucket != c.bucket || key != c.key || versionID != c.versionID {
+			t.Errorf("case %d: expected: %s, %s, %s, got: %s, %s, %s", i, c.bucket, c.key, c.versionID, bucket, key, versionID)
+		}
+	}
+}
+
+func TestFilterKeys(t *testing.T) {
+	keys := []string{"b.txt", "a.txt", "dir/c.txt", "dir/sub/d.txt"}
+	cases := []struct {
+		prefix, delimiter string
+		expected          []string
+	}{
+		{expected: []string{"a.txt", "b.txt", "dir/c.txt", "dir/sub/d.txt"}},
+		{delimiter: "/", expected: []string{"a.txt", "b.txt"}},
+		{prefix: "dir/", expected: []string{"dir/c.txt", "dir/sub/d.txt"}},
+		{prefix: "dir/", delimiter: "/", expected: []string{"dir/c.txt"}},
+		{prefix: "missing/"},
+	}
+	for i, c := range cases {
+		if list := filterKeys(keys, c.prefix, c.delimiter); !reflect.DeepEqual(list, c.expected) {
+			t.Errorf("case %d: expected: %v, got: %v", i, c.expected, list)
+		}
+	}
+}
+
+func TestBucketRegion(t *testing.T) {
+	locations := map[string]string{"located": "eu-west-2", "legacy": "EU", "classic": ""}
+	lookups := make(map[string]int, 0)
+	var lock sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bucket := strings.Trim(r.URL.Path, "/")
+		lock.Lock()
+		lookups[bucket]++
+		lock.Unlock()
+
+		location, found := locations[bucket]
+		switch {
+		case found:
+			fmt.Fprintf(w, `<LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">%s</LocationConstraint>`, location)
+		case bucket == "denied":
+			w.Header().Set("X-Amz-Bucket-Region", "ap-southeast-2")
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	config := session.New(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials("key", "secret", ""),
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("eu-west-1"),
+		S3ForcePathStyle: aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	})
+
+	cases := map[string]string{
+		"located": "eu-west-2",
+		"legacy":  "eu-west-1",
+		"classic": "us-east-1",
+		"denied":  "ap-southeast-2",
+		"missing": "eu-west-1",
+	}
+	store := newAWSStore(config, true).(*awsStore)
+	for bucket, expected := range cases {
+		for i := 0; i < 2; i++ {
+			client := store.clients(bucket).client
+			if region := aws.StringValue(client.Config.Region); region != expected {
+				t.Errorf("bucket: %s, expected the region: %s, got: %s", bucket, expected, region)
+			}
+		}
+	}
+	// step: the region is only looked up once per bucket
+	for bucket := range cases {
+		if n := lookups[bucket]; n == 0 || n > 2 {
+			t.Errorf("bucket: %s, expected the region to be looked up once, got: %d requests", bucket, n)
+		}
+	}
+	if store.clients("located") != store.clients("located") || store.clients("legacy") != store.defaults {
+		t.Errorf("expected the clients of a region to be reused")
+	}
+
+	// step: without detection the default region is always used
+	store = newAWSStore(config, false).(*awsStore)
+	lookups = make(map[string]int, 0)
+	if store.clients("located") != store.defaults || len(lookups) != 0 {
+		t.Errorf("expected the default clients without a lookup")
+	}
+}
+
+func TestNormalizeBucketLocation(t *testing.T) {
+	cases := map[string]string{
+		"":          "us-east-1",
+		"EU":        "eu-west-1",
+		"eu-west-2": "eu-west-2",
+	}
+	for location, expected := range cases {
+		if region := normalizeBucketLocation(location); region != expected {
+			t.Errorf("location: %q, expected: %s, got: %s", location, expected, region)
+		}
+	}
+}
+
+func TestLocalStoreEncryption(t *testing.T) {
+	dir, cleanup := newTestDir(t, nil)
+	defer cleanup()
+
+	keyService, err := newLocalKeyService(filepath.Join(dir, localKeysDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := newLocalStore(dir, keyService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &cliCommand{store: store, keyService: keyService}
+	if err := store.CreateBucket(testBucket); err != nil {
+		t.Fatal(err)
+	}
+	putTestFile(t, cmd, "a.txt", "first hunter2", false)
+	putTestFile(t, cmd, "a.txt", "second hunter2", false)
+
+	// step: neither the current or previous version is written in the clear
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(content), "hunter2") {
+			t.Errorf("the file: %s holds the content in the clear", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content := getTestFile(t, cmd, "a.txt"); content != "second hunter2" {
+		t.Errorf("expected the content: second hunter2, got: %q", content)
+	}
+	head, err := store.Head(testBucket, "a.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := aws.Int64Value(head.ContentLength); size != int64(len("second hunter2")) {
+		t.Errorf("expected the size of the plaintext, got: %d", size)
+	}
+	var previous string
+	err = store.ListVersions(testBucket, "a.txt", func(x *s3.ObjectVersion) error {
+		if !aws.BoolValue(x.IsLatest) {
+			previous = aws.StringValue(x.VersionId)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := store.Get(testBucket, "a.txt", previous)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "first hunter2" {
+		t.Errorf("expected the previous content: first hunter2, got: %q", content)
+	}
+}
@@ -0,0 +1,336 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+//
+// newTemplateCommand creates a new template command
+//
+func newTemplateCommand(cmd *cliCommand) cli.Command {
+	return cli.Command{
+		Name:      "template",
+		Usage:     "renders one or more templates using the content of files from the s3 bucket",
+		ArgsUsage: "TEMPLATE[:DESTINATION]...",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "b, bucket",
+				Usage:  "the name of the s3 bucket containing the encrypted files",
+				EnvVar: "AWS_S3_BUCKET",
+			},
+			cli.StringFlag{
+				Name:  "p, perms",
+				Usage: "the file permissions on the rendered files",
+				Value: "0600",
+			},
+			cli.BoolFlag{
+				Name:  "sync",
+				Usage: "continously re-render the templates when the files they reference change",
+			},
+			cli.DurationFlag{
+				Name:  "sync-interval",
+				Usage: "the time interval between successive pollings, i.e how long we should wait to recheck",
+				Value: time.Duration(30 * time.Second),
+			},
+		},
+		Action: func(cx *cli.Context) error {
+			return handleCommand(cx, []string{"l:bucket:s"}, cmd, renderTemplates)
+		},
+	}
+}
+
+// templateRenderer renders a template from the secrets in the bucket
+type templateRenderer struct {
+	// the command used to retrieve the files
+	cmd *cliCommand
+	// the bucket containing the files
+	bucket string
+	// the path to the template
+	source string
+	// the path to write the rendered content, empty for stdout
+	destination string
+	// the modification time of the template at the last render
+	modified time.Time
+	// the etags of the files referenced by the last render
+	tags map[string]string
+	// the etags of the files referenced in the present render
+	pending map[string]string
+	// a cache of the files retrieved in the present render
+	cache map[string][]byte
+}
+
+//
+// renderTemplates renders the templates, optionally rendering again when the referenced files change
+//
+func renderTemplates(o *formatter, cx *cli.Context, cmd *cliCommand) error {
+	bucket := cx.String("bucket")
+	syncEnabled := cx.Bool("sync")
+	syncInterval := cx.Duration("sync-interval")
+
+	if len(cx.Args()) <= 0 {
+		return fmt.Errorf("you have not specified any templates to render")
+	}
+	mode, err := parseFileMode(cx.String("perms"))
+	if err != nil {
+		return err
+	}
+
+	// step: create a renderer for each of the templates
+	var renderers []*templateRenderer
+	for _, x := range cx.Args() {
+		items := strings.SplitN(x, ":", 2)
+		renderer := &templateRenderer{cmd: cmd, bucket: bucket, source: items[0]}
+		if len(items) == 2 {
+			renderer.destination = items[1]
+		}
+		if syncEnabled && renderer.destination == "" {
+			return fmt.Errorf("the template: %s must have a destination when syncing", renderer.source)
+		}
+		renderers = append(renderers, renderer)
+	}
+
+	// step: create a signal to handle exits and a ticker for intervals
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	tickerCh := time.NewTicker(syncInterval)
+	defer tickerCh.Stop()
+
+	for {
+		for _, x := range renderers {
+			// step: check if any of the referenced files have changed and render
+			rendered, err := x.refresh(mode)
+			if err != nil {
+				o.fields(map[string]interface{}{
+					"action":   "template",
+					"bucket":   bucket,
+					"template": x.source,
+					"error":    err.Error(),
+				}).log("failed to render the template: %s, error: %s\n", x.source, err)
+
+				if !syncEnabled {
+					return err
+				}
+				continue
+			}
+			if rendered && x.destination != "" {
+				o.fields(map[string]interface{}{
+					"action":      "template",
+					"bucket":      bucket,
+					"template":    x.source,
+					"destination": x.destination,
+				}).log("rendered the template: %s and wrote to: %s\n", x.source, x.destination)
+			}
+		}
+		// step: if we are not in a sync loop we can exit
+		if !syncEnabled {
+			return nil
+		}
+
+		select {
+		case <-tickerCh.C:
+		case <-signalCh:
+			o.log("exitting the synchronzition service\n")
+			return nil
+		}
+	}
+}
+
+//
+// refresh renders the template if this is the first time or any of the referenced files have changed
+//
+func (r *templateRenderer) refresh(mode os.FileMode) (bool, error) {
+	if changed, err := r.changed(); err != nil || !changed {
+		return false, err
+	}
+
+	return true, r.render(mode)
+}
+
+//
+// changed checks if the template or any of the files referenced by the last render have changed
+//
+func (r *templateRenderer) changed() (bool, error) {
+	if r.tags == nil {
+		return true, nil
+	}
+	stat, err := os.Stat(r.source)
+	if err != nil {
+		return false, err
+	}
+	if !stat.ModTime().Equal(r.modified) {
+		return true, nil
+	}
+	for key, etag := range r.tags {
+		metadata, err := r.cmd.getFileMetadata(key, r.bucket)
+		if err != nil {
+			return false, fmt.Errorf("unable to retrieve the file: %s, error: %s", key, err)
+		}
+		if aws.StringValue(metadata.ETag) != etag {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+//
+// render renders the template and writes the content to the destination
+//
+func (r *templateRenderer) render(mode os.FileMode) error {
+	// step: record the modification time before reading, so a change while rendering is picked up
+	stat, err := os.Stat(r.source)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(r.source)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(filepath.Base(r.source)).Option("missingkey=error").Funcs(template.FuncMap{
+		"secret":     r.secret,
+		"secretJSON": r.secretJSON,
+		"secretYAML": r.secretYAML,
+	}).Parse(string(content))
+	if err != nil {
+		return err
+	}
+
+	// step: render the template, capturing the files referenced
+	r.cache = make(map[string][]byte, 0)
+	r.pending = make(map[string]string, 0)
+	defer func() { r.cache = nil }()
+
+	buffer := new(bytes.Buffer)
+	if err := tmpl.Execute(buffer, nil); err != nil {
+		return err
+	}
+	r.tags = r.pending
+	r.modified = stat.ModTime()
+
+	if r.destination == "" {
+		_, err := os.Stdout.Write(buffer.Bytes())
+		return err
+	}
+
+	return writeFile(r.destination, buffer.Bytes(), defaultFileOptions(mode))
+}
+
+//
+// fetch retrieves the content of a file from the bucket, recording it's etag
+//
+func (r *templateRenderer) fetch(key string) ([]byte, error) {
+	if content, found := r.cache[key]; found {
+		return content, nil
+	}
+	content, etag, err := r.cmd.getFileWithETag(r.bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve the file: %s, error: %s", key, err)
+	}
+	r.cache[key] = content
+	r.pending[key] = etag
+
+	return content, nil
+}
+
+// secret is a template method returning the content of the file
+func (r *templateRenderer) secret(key string) (string, error) {
+	content, err := r.fetch(key)
+
+	return string(content), err
+}
+
+// secretJSON is a template method returning a field from a json file
+func (r *templateRenderer) secretJSON(key, field string) (string, error) {
+	content, err := r.fetch(key)
+	if err != nil {
+		return "", err
+	}
+	var document interface{}
+	if err := decodeJSON(content, &document); err != nil {
+		return "", fmt.Errorf("the file: %s is not valid json, error: %s", key, err)
+	}
+
+	return lookupVariable(document, key, field)
+}
+
+// secretYAML is a template method returning a field from a yaml file
+func (r *templateRenderer) secretYAML(key, field string) (string, error) {
+	content, err := r.fetch(key)
+	if err != nil {
+		return "", err
+	}
+	var document interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return "", fmt.Errorf("the file: %s is not valid yaml, error: %s", key, err)
+	}
+
+	return lookupVariable(normalizeYAML(document), key, field)
+}
+
+//
+// lookupVariable finds the field in the document and converts it to a string
+//
+func lookupVariable(document interface{}, key, field string) (string, error) {
+	value, err := lookupField(document, field)
+	if err != nil {
+		return "", fmt.Errorf("file: %s, %s", key, err)
+	}
+
+	return toVariable(value)
+}
+
+//
+// lookupField finds the value of a dotted field i.e. a.b.0.c within the document
+//
+func lookupField(document interface{}, field string) (interface{}, error) {
+	value := document
+	for _, name := range strings.Split(field, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			x, found := v[name]
+			if !found {
+				return nil, fmt.Errorf("field: %s not found", field)
+			}
+			value = x
+		case []interface{}:
+			index, err := strconv.Atoi(name)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, fmt.Errorf("field: %s has an invalid index: %s", field, name)
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("field: %s not found", field)
+		}
+	}
+
+	return value, nil
+}
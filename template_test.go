@@ -0,0 +1,155 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenderTemplates(t *testing.T) {
+	cases := []struct {
+		template string
+		expected string
+		error    bool
+	}{
+		{
+			template: `password={{ secret "password.txt" }}`,
+			expected: "password=secret",
+		},
+		{
+			template: `{{ secretJSON "config.json" "db.password" }}:{{ secretJSON "config.json" "db.port" }}`,
+			expected: "json:5432",
+		},
+		{
+			template: `{{ secretJSON "config.json" "account" }}`,
+			expected: "123456789012",
+		},
+		{
+			template: `{{ secretYAML "config.yaml" "db.password" }}`,
+			expected: "yaml",
+		},
+		{
+			template: `{{ secret "password.txt" }}{{ secret "password.txt" }}`,
+			expected: "secretsecret",
+		},
+		{
+			template: `{{ secret "missing.txt" }}`,
+			error:    true,
+		},
+		{
+			template: `{{ secretJSON "config.json" "db.missing" }}`,
+			error:    true,
+		},
+		{
+			template: `{{ secretJSON "config.yaml" "db.password" }}`,
+			error:    true,
+		},
+		{
+			template: `{{ secret }`,
+			error:    true,
+		},
+	}
+	cmd := newTestCommand(t)
+	putTestFile(t, cmd, "password.txt", "secret", true)
+	putTestFile(t, cmd, "config.json", `{"account": 123456789012, "db": {"password": "json", "port": 5432}}`, false)
+	putTestFile(t, cmd, "config.yaml", "db:\n  password: yaml\n", true)
+
+	for i, c := range cases {
+		dir, cleanup := newTestDir(t, map[string]string{"config.tmpl": c.template})
+		source := filepath.Join(dir, "config.tmpl")
+		destination := filepath.Join(dir, "config")
+		_, err := runTestCommand(t, cmd, newTemplateCommand(cmd), renderTemplates,
+			"--bucket", testBucket, source+":"+destination)
+		content, _ := ioutil.ReadFile(destination)
+		cleanup()
+		if c.error {
+			if err == nil {
+				t.Errorf("case %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if string(content) != c.expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, content)
+		}
+	}
+}
+
+func TestTemplateRefresh(t *testing.T) {
+	cmd := newTestCommand(t)
+	putTestFile(t, cmd, "password.txt", "first", false)
+	dir, cleanup := newTestDir(t, map[string]string{"config.tmpl": `{{ secret "password.txt" }}`})
+	defer cleanup()
+	renderer := &templateRenderer{
+		cmd:         cmd,
+		bucket:      testBucket,
+		source:      filepath.Join(dir, "config.tmpl"),
+		destination: filepath.Join(dir, "config"),
+	}
+
+	cases := []struct {
+		// the change made before the refresh
+		change   func()
+		rendered bool
+		expected string
+	}{
+		{rendered: true, expected: "first"},
+		{rendered: false, expected: "first"},
+		{
+			change:   func() { putTestFile(t, cmd, "password.txt", "second", true) },
+			rendered: true,
+			expected: "second",
+		},
+		{rendered: false, expected: "second"},
+		{
+			change: func() {
+				if err := ioutil.WriteFile(renderer.source, []byte(`[{{ secret "password.txt" }}]`), 0644); err != nil {
+					t.Fatal(err)
+				}
+				modified := time.Now().Add(time.Hour)
+				if err := os.Chtimes(renderer.source, modified, modified); err != nil {
+					t.Fatal(err)
+				}
+			},
+			rendered: true,
+			expected: "[second]",
+		},
+		{rendered: false, expected: "[second]"},
+	}
+	for i, c := range cases {
+		if c.change != nil {
+			c.change()
+		}
+		rendered, err := renderer.refresh(0600)
+		if err != nil {
+			t.Errorf("case %d: unexpected error: %s", i, err)
+			continue
+		}
+		if rendered != c.rendered {
+			t.Errorf("case %d: expected rendered: %t, got: %t", i, c.rendered, rendered)
+		}
+		if content, _ := ioutil.ReadFile(renderer.destination); string(content) != c.expected {
+			t.Errorf("case %d: expected: %q, got: %q", i, c.expected, content)
+		}
+	}
+}
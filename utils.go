@@ -16,8 +16,11 @@ limitations under the License.
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/urfave/cli"
 )
@@ -67,3 +70,82 @@ func expandFiles(path string) ([]string, error) {
 
 	return list, err
 }
+
+// parseFileMode parses an octal file permission, i.e. 0600
+func parseFileMode(perms string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(perms, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid file permissions: '%s', expected an octal mode i.e. 0600", perms)
+	}
+
+	return os.FileMode(mode), nil
+}
+
+// fileOptions are the permissions and ownership applied to the files we write
+type fileOptions struct {
+	// the permissions on the file
+	mode os.FileMode
+	// the permissions on any directories created
+	dirMode os.FileMode
+	// the user and group to own the file, -1 leaves them unchanged
+	uid, gid int
+}
+
+// defaultFileOptions returns the options for a private file owned by us
+func defaultFileOptions(mode os.FileMode) fileOptions {
+	return fileOptions{mode: mode, dirMode: 0755, uid: -1, gid: -1}
+}
+
+// writeFile atomically writes the content to the path, i.e. via a temporary file in the same directory which is
+// renamed over the destination, so readers never observe a partially written file
+func writeFile(path string, content []byte, options fileOptions) error {
+	return writeFileWith(path, options, func(file *os.File) error {
+		_, err := file.Write(content)
+		return err
+	})
+}
+
+// writeFileWith atomically writes the file as per writeFile, with the method writing the content
+func writeFileWith(path string, options fileOptions, method func(*os.File) error) error {
+	// step: ensure the directory structure
+	if err := os.MkdirAll(filepath.Dir(path), options.dirMode); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	// step: ensure the temporary file is removed if we fail
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	// step: set the permissions and ownership before any content is written
+	if err := tmp.Chmod(options.mode); err != nil {
+		return err
+	}
+	if options.uid != -1 || options.gid != -1 {
+		if err := tmp.Chown(options.uid, options.gid); err != nil {
+			return err
+		}
+	}
+	if err := method(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	renamed = true
+
+	return nil
+}
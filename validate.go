@@ -0,0 +1,191 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// validator checks the content of a file is well formed
+type validator func(content []byte) error
+
+// validators are the available content validators, keyed by name
+var validators = map[string]validator{
+	"json": validateJSON,
+	"yaml": validateYAML,
+	"env":  validateEnvironment,
+	"pem":  validatePEM,
+	"toml": validateTOML,
+}
+
+// validatorExtensions selects the validator from the extension of the file
+var validatorExtensions = map[string]string{
+	".json": "json",
+	".yml":  "yaml",
+	".yaml": "yaml",
+	".env":  "env",
+	".pem":  "pem",
+	".crt":  "pem",
+	".cert": "pem",
+	".key":  "pem",
+	".toml": "toml",
+}
+
+//
+// selectValidator returns the validator for a file, auto, the default, selects by the extension of the file
+// and none disables validation, a nil validator is returned when there's nothing to validate
+//
+func selectValidator(name, filename string) (validator, error) {
+	switch name {
+	case "", "none":
+		return nil, nil
+	case "auto":
+		name = validatorExtensions[strings.ToLower(filepath.Ext(filename))]
+		if name == "" {
+			return nil, nil
+		}
+	}
+	v, found := validators[name]
+	if !found {
+		return nil, fmt.Errorf("unsupported validator: %s, expected auto, none or one of %s", name, strings.Join(validatorNames(), ", "))
+	}
+
+	return v, nil
+}
+
+//
+// validateContent validates the content of the file with the validator, if any
+//
+func validateContent(check validator, filename string, content []byte) error {
+	if check == nil {
+		return nil
+	}
+	if err := check(content); err != nil {
+		return fmt.Errorf("the file: %s is invalid, %s", filename, err)
+	}
+
+	return nil
+}
+
+//
+// validatorNames returns the names of the validators
+//
+func validatorNames() []string {
+	var names []string
+	for name := range validators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+//
+// validateJSON checks the content is a json document
+//
+func validateJSON(content []byte) error {
+	var document interface{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("invalid json: %s", err)
+	}
+
+	return nil
+}
+
+//
+// validateYAML checks the content is a yaml document
+//
+func validateYAML(content []byte) error {
+	var document interface{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("invalid yaml: %s", err)
+	}
+
+	return nil
+}
+
+//
+// validateEnvironment checks the content is a dotenv document
+//
+func validateEnvironment(content []byte) error {
+	if _, err := parseEnvironment(content); err != nil {
+		return fmt.Errorf("invalid environment file: %s", err)
+	}
+
+	return nil
+}
+
+//
+// validateTOML checks the content is a toml document
+//
+func validateTOML(content []byte) error {
+	var document interface{}
+	if _, err := toml.Decode(string(content), &document); err != nil {
+		return fmt.Errorf("invalid toml: %s", err)
+	}
+
+	return nil
+}
+
+//
+// validatePEM checks the content is one or more pem blocks, parsing any certificates and keys
+//
+func validatePEM(content []byte) error {
+	var count int
+	for rest := content; len(bytes.TrimSpace(rest)) > 0; count++ {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			return fmt.Errorf("invalid pem: block %d is not pem encoded", count+1)
+		}
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			_, err = x509.ParseCertificate(block.Bytes)
+		case "PRIVATE KEY":
+			if _, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+				_, err = parseEd25519PrivateKey(block.Bytes)
+			}
+		case "RSA PRIVATE KEY":
+			_, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			_, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			if _, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+				_, err = parseEd25519PublicKey(block.Bytes)
+			}
+		case "CERTIFICATE REQUEST":
+			_, err = x509.ParseCertificateRequest(block.Bytes)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid pem: block %d (%s), %s", count+1, block.Type, err)
+		}
+	}
+	if count == 0 {
+		return fmt.Errorf("invalid pem: no pem blocks found")
+	}
+
+	return nil
+}
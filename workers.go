@@ -0,0 +1,103 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// errTaskSkipped is recorded against the tasks which were not started due to an earlier failure
+var errTaskSkipped = errors.New("skipped due to an earlier failure")
+
+//
+// runTasks runs the tasks over a bounded number of workers, returning the error of each task in order. The
+// output of each task is buffered and written in the order of the tasks, so it is never interleaved. When
+// fail fast is set no further tasks are started once one has failed.
+//
+func runTasks(o *formatter, concurrency int, failFast bool, tasks []func(*formatter) error) []error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	errs := make([]error, len(tasks))
+	buffers := make([]*bytes.Buffer, len(tasks))
+	done := make([]chan struct{}, len(tasks))
+	for i := range tasks {
+		buffers[i] = new(bytes.Buffer)
+		done[i] = make(chan struct{})
+	}
+
+	// step: start the workers
+	var failed int32
+	queue := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				switch {
+				case failFast && atomic.LoadInt32(&failed) == 1:
+					errs[i] = errTaskSkipped
+				default:
+					if errs[i] = tasks[i](o.buffered(buffers[i])); errs[i] != nil {
+						atomic.StoreInt32(&failed, 1)
+					}
+				}
+				close(done[i])
+			}
+		}()
+	}
+	go func() {
+		for i := range tasks {
+			queue <- i
+		}
+		close(queue)
+	}()
+
+	// step: write the output of the tasks in order as they complete
+	for i := range tasks {
+		<-done[i]
+		o.writer.Write(buffers[i].Bytes())
+	}
+	wg.Wait()
+
+	return errs
+}
+
+//
+// aggregateErrors combines the errors of the tasks into a single error, ignoring any skipped tasks
+//
+func aggregateErrors(errs []error) error {
+	var messages []string
+	for _, err := range errs {
+		if err != nil && err != errTaskSkipped {
+			messages = append(messages, err.Error())
+		}
+	}
+	switch len(messages) {
+	case 0:
+		return nil
+	case 1:
+		return errors.New(messages[0])
+	}
+
+	return fmt.Errorf("%d of %d files failed, %s", len(messages), len(errs), strings.Join(messages, "; "))
+}
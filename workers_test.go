@@ -0,0 +1,129 @@
+/*
+Copyright 2015 All rights reserved.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunTasks(t *testing.T) {
+	output := new(bytes.Buffer)
+	o, err := newFormatter("text", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// step: the later tasks complete first, but the output must be in the order of the tasks
+	var running, peak int32
+	var tasks []func(*formatter) error
+	for i := 0; i < 8; i++ {
+		n := i
+		tasks = append(tasks, func(o *formatter) error {
+			current := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				previous := atomic.LoadInt32(&peak)
+				if current <= previous || atomic.CompareAndSwapInt32(&peak, previous, current) {
+					break
+				}
+			}
+			time.Sleep(time.Duration(8-n) * time.Millisecond)
+			o.log("task %d\n", n)
+			if n == 5 {
+				return fmt.Errorf("task %d failed", n)
+			}
+			return nil
+		})
+	}
+	errs := runTasks(o, 3, false, tasks)
+
+	expected := ""
+	for i := range tasks {
+		expected += fmt.Sprintf("task %d\n", i)
+	}
+	if output.String() != expected {
+		t.Errorf("expected the output: %q, got: %q", expected, output.String())
+	}
+	if peak > 3 {
+		t.Errorf("expected at most 3 tasks to run at once, got: %d", peak)
+	}
+	for i, err := range errs {
+		if (err != nil) != (i == 5) {
+			t.Errorf("task %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestRunTasksFailFast(t *testing.T) {
+	o, err := newFormatter("text", new(bytes.Buffer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started int32
+	var tasks []func(*formatter) error
+	for i := 0; i < 5; i++ {
+		n := i
+		tasks = append(tasks, func(o *formatter) error {
+			atomic.AddInt32(&started, 1)
+			if n == 1 {
+				return fmt.Errorf("task %d failed", n)
+			}
+			return nil
+		})
+	}
+	errs := runTasks(o, 1, true, tasks)
+	if started != 2 {
+		t.Errorf("expected no tasks to start after the failure, got: %d started", started)
+	}
+	for i, err := range errs[2:] {
+		if err != errTaskSkipped {
+			t.Errorf("task %d: expected to be skipped, got: %v", i+2, err)
+		}
+	}
+
+	// step: the skipped tasks are not reported
+	if err := aggregateErrors(errs); err == nil || err.Error() != "task 1 failed" {
+		t.Errorf("expected the single failure, got: %v", err)
+	}
+}
+
+func TestAggregateErrors(t *testing.T) {
+	cases := []struct {
+		errs     []error
+		expected string
+	}{
+		{errs: []error{nil, nil}},
+		{errs: []error{nil, errTaskSkipped}},
+		{errs: []error{fmt.Errorf("a failed"), nil}, expected: "a failed"},
+		{errs: []error{fmt.Errorf("a failed"), nil, fmt.Errorf("c failed")}, expected: "2 of 3 files failed, a failed; c failed"},
+	}
+	for i, c := range cases {
+		err := aggregateErrors(c.errs)
+		if c.expected == "" {
+			if err != nil {
+				t.Errorf("case %d: unexpected error: %s", i, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != c.expected {
+			t.Errorf("case %d: expected the error: %q, got: %v", i, c.expected, err)
+		}
+	}
+}